@@ -0,0 +1,193 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+
+	"github.com/marcus-sa/tor-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+const (
+	// hubSpecAnnotation keeps the v1beta1 spec of an OnionService read as
+	// v1alpha1, so fields v1alpha1 cannot represent survive an update.
+	hubSpecAnnotation = "tor.k8s.io/v1beta1-spec"
+	// hubStatusAnnotation keeps the v1beta1 status the same way, so status
+	// updates of v1alpha1 clients keep the progress of migrations, key
+	// rotations and vanity searches.
+	hubStatusAnnotation = "tor.k8s.io/v1beta1-status"
+	// extraConfigAnnotation keeps the deprecated extraConfig, which has no
+	// counterpart in v1beta1.
+	extraConfigAnnotation = "tor.k8s.io/v1alpha1-extra-config"
+)
+
+// ConvertTo converts this OnionService to the Hub version (v1beta1).
+func (src *OnionService) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.OnionService)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	// start from the last v1beta1 spec and status to keep fields unknown to
+	// v1alpha1
+	if data, ok := src.Annotations[hubSpecAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &dst.Spec); err != nil {
+			return err
+		}
+	}
+	if data, ok := src.Annotations[hubStatusAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &dst.Status); err != nil {
+			return err
+		}
+	}
+	// the annotations are only meaningful to v1alpha1 clients and must not
+	// be served as part of v1beta1 objects
+	delete(dst.Annotations, hubSpecAnnotation)
+	delete(dst.Annotations, hubStatusAnnotation)
+	if len(dst.Annotations) == 0 {
+		dst.Annotations = nil
+	}
+	namedPorts := map[int32]intstr.IntOrString{}
+	for _, p := range dst.Spec.Backend.Ports {
+		if p.TargetPort.Type == intstr.String {
+			namedPorts[p.PublicPort] = p.TargetPort
+		}
+	}
+
+	dst.Spec.Version = src.Spec.Version
+	dst.Spec.Mode = v1beta1.OnionServiceMode(src.Spec.Mode)
+	dst.Spec.Backend.Selector = src.Spec.Selector
+	dst.Spec.Backend.Ports = nil
+	for _, p := range src.Spec.Ports {
+		port := v1beta1.ServicePort{
+			Name:       p.Name,
+			PublicPort: p.PublicPort,
+			TargetPort: intstr.FromInt(int(p.TargetPort)),
+		}
+		if named, ok := namedPorts[p.PublicPort]; ok && p.TargetPort == 0 {
+			port.TargetPort = named
+		}
+		dst.Spec.Backend.Ports = append(dst.Spec.Backend.Ports, port)
+	}
+
+	dst.Spec.PrivateKeySecret = nil
+	if src.Spec.PrivateKeySecret != (SecretReference{}) {
+		dst.Spec.PrivateKeySecret = &v1beta1.SecretReference{
+			Name: src.Spec.PrivateKeySecret.Name,
+			Key:  src.Spec.PrivateKeySecret.Key,
+		}
+	}
+
+	dst.Spec.TorOptions = (*v1beta1.TorOptions)(src.Spec.TorOptions.DeepCopy())
+	dst.Spec.SelfTest = (*v1beta1.SelfTestSpec)(src.Spec.SelfTest.DeepCopy())
+	dst.Spec.DoSDefense = (*v1beta1.DoSDefenseSpec)(src.Spec.DoSDefense.DeepCopy())
+	dst.Spec.OnionLocation = (*v1beta1.OnionLocationSpec)(src.Spec.OnionLocation.DeepCopy())
+	dst.Spec.HostnameTarget = (*v1beta1.HostnameTargetSpec)(src.Spec.HostnameTarget.DeepCopy())
+	dst.Spec.Pool = src.Spec.Pool
+
+	if src.Spec.ExtraConfig != "" {
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[extraConfigAnnotation] = src.Spec.ExtraConfig
+	}
+
+	dst.Status.Hostname = src.Status.Hostname
+	dst.Status.TargetClusterIP = src.Status.TargetClusterIP
+	dst.Status.Descriptor = v1beta1.DescriptorStatus(*src.Status.Descriptor.DeepCopy())
+	dst.Status.Conditions = nil
+	for _, c := range src.Status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, v1beta1.OnionServiceCondition{
+			Type:               v1beta1.OnionServiceConditionType(c.Type),
+			Status:             c.Status,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
+		})
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *OnionService) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.OnionService)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	spec, err := json.Marshal(src.Spec)
+	if err != nil {
+		return err
+	}
+	status, err := json.Marshal(src.Status)
+	if err != nil {
+		return err
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[hubSpecAnnotation] = string(spec)
+	dst.Annotations[hubStatusAnnotation] = string(status)
+
+	dst.Spec.ExtraConfig = dst.Annotations[extraConfigAnnotation]
+	delete(dst.Annotations, extraConfigAnnotation)
+
+	dst.Spec.Version = src.Spec.Version
+	dst.Spec.Mode = OnionServiceMode(src.Spec.Mode)
+	dst.Spec.Selector = src.Spec.Backend.Selector
+	dst.Spec.Ports = nil
+	for _, p := range src.Spec.Backend.Ports {
+		// named target ports are kept in the annotation only
+		dst.Spec.Ports = append(dst.Spec.Ports, ServicePort{
+			Name:       p.Name,
+			PublicPort: p.PublicPort,
+			TargetPort: p.TargetPort.IntVal,
+		})
+	}
+
+	dst.Spec.PrivateKeySecret = SecretReference{}
+	if src.Spec.PrivateKeySecret != nil {
+		dst.Spec.PrivateKeySecret = SecretReference{
+			Name: src.Spec.PrivateKeySecret.Name,
+			Key:  src.Spec.PrivateKeySecret.Key,
+		}
+	}
+
+	dst.Spec.TorOptions = (*TorOptions)(src.Spec.TorOptions.DeepCopy())
+	dst.Spec.SelfTest = (*SelfTestSpec)(src.Spec.SelfTest.DeepCopy())
+	dst.Spec.DoSDefense = (*DoSDefenseSpec)(src.Spec.DoSDefense.DeepCopy())
+	dst.Spec.OnionLocation = (*OnionLocationSpec)(src.Spec.OnionLocation.DeepCopy())
+	dst.Spec.HostnameTarget = (*HostnameTargetSpec)(src.Spec.HostnameTarget.DeepCopy())
+	dst.Spec.Pool = src.Spec.Pool
+
+	dst.Status.Hostname = src.Status.Hostname
+	dst.Status.TargetClusterIP = src.Status.TargetClusterIP
+	dst.Status.Descriptor = DescriptorStatus(*src.Status.Descriptor.DeepCopy())
+	dst.Status.Conditions = nil
+	for _, c := range src.Status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, OnionServiceCondition{
+			Type:               OnionServiceConditionType(c.Type),
+			Status:             c.Status,
+			LastTransitionTime: c.LastTransitionTime,
+			Reason:             c.Reason,
+			Message:            c.Message,
+		})
+	}
+
+	return nil
+}
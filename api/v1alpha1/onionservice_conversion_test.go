@@ -0,0 +1,266 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcus-sa/tor-operator/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func alphaOnionService() *OnionService {
+	rate := resource.MustParse("1Mi")
+	return &OnionService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "example",
+			Namespace:   "default",
+			Labels:      map[string]string{"app": "example"},
+			Annotations: map[string]string{"example.com/owner": "team"},
+		},
+		Spec: OnionServiceSpec{
+			Ports: []ServicePort{
+				{Name: "http", PublicPort: 80, TargetPort: 8080},
+				{Name: "https", PublicPort: 443, TargetPort: 8443},
+			},
+			Selector:         map[string]string{"app": "example"},
+			PrivateKeySecret: SecretReference{Name: "example-key", Key: "private_key"},
+			Version:          3,
+			Mode:             SingleOnionMode,
+			ExtraConfig:      "HiddenServiceMaxStreams 10",
+			TorOptions: &TorOptions{
+				NumIntroductionPoints: int32Ptr(5),
+				BandwidthRate:         &rate,
+				LogLevel:              "info",
+			},
+			SelfTest:       &SelfTestSpec{Interval: metav1.Duration{Duration: time.Hour}, Protocol: "http"},
+			DoSDefense:     &DoSDefenseSpec{IntroDoSDefenseEnabled: true, IntroDoSRatePerSec: int32Ptr(25)},
+			OnionLocation:  &OnionLocationSpec{IngressName: "example", IngressClass: IngressClassNginx},
+			HostnameTarget: &HostnameTargetSpec{Kind: HostnameTargetConfigMap, Name: "example", Key: DefaultHostnameTargetKey},
+		},
+		Status: OnionServiceStatus{
+			Hostname:        "25njqamcweflpvkl73j4szahhihoc4xt3ktcgjnpaingr5yhkenl5sid.onion",
+			TargetClusterIP: "10.0.0.1",
+			Descriptor:      DescriptorStatus{HSDirs: 6, FailedHSDirs: 1},
+			Conditions: []OnionServiceCondition{
+				{Type: OnionServiceReachable, Status: corev1.ConditionTrue, Reason: "SelfTestSucceeded"},
+			},
+		},
+	}
+}
+
+func hubOnionService() *v1beta1.OnionService {
+	now := metav1.NewTime(time.Unix(1600000000, 0))
+	return &v1beta1.OnionService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example",
+			Namespace: "default",
+		},
+		Spec: v1beta1.OnionServiceSpec{
+			Version: 3,
+			Backend: v1beta1.BackendSpec{
+				Selector: map[string]string{"app": "example"},
+				Ports: []v1beta1.ServicePort{
+					{Name: "http", PublicPort: 80, TargetPort: intstr.FromString("http")},
+					{Name: "metrics", PublicPort: 9090, TargetPort: intstr.FromInt(9090)},
+				},
+			},
+			PrivateKeySecret: &v1beta1.SecretReference{Name: "example-key", Key: "private_key"},
+			Pool:             "frontends",
+			Migration:        &v1beta1.MigrationSpec{KeySecretName: "example-v3"},
+			KeyRotation:      &v1beta1.KeyRotationSpec{Token: "2020-09", Overlap: metav1.Duration{Duration: time.Hour}},
+			VanityPrefix:     "example",
+			KeyProvider: &v1beta1.KeyProviderSpec{Vault: &v1beta1.VaultKeyProvider{
+				Address: "https://vault:8200",
+				Path:    "onions/example",
+				Role:    "tor",
+			}},
+			NetworkPolicy: &v1beta1.NetworkPolicySpec{APIServerCIDRs: []string{"10.0.0.1/32"}},
+			Rollout:       &v1beta1.RolloutSpec{Strategy: appsv1.RollingUpdateDeploymentStrategyType},
+		},
+		Status: v1beta1.OnionServiceStatus{
+			Hostname:          "25njqamcweflpvkl73j4szahhihoc4xt3ktcgjnpaingr5yhkenl5sid.onion",
+			TargetClusterIP:   "10.0.0.1",
+			ExpectedHostname:  "25njqamcweflpvkl73j4szahhihoc4xt3ktcgjnpaingr5yhkenl5sid.onion",
+			MigrationHostname: "25njqamcweflpvkl73j4szahhihoc4xt3ktcgjnpaingr5yhkenl5sid.onion",
+			OnionLocation:     &v1beta1.OnionLocationSpec{IngressName: "example", IngressClass: v1beta1.IngressClassNginx},
+			KeyRotation: &v1beta1.KeyRotationStatus{
+				Token:             "2020-09",
+				KeySecret:         v1beta1.SecretReference{Name: "example-2020-09", Key: "hs_ed25519_secret_key"},
+				Hostname:          "25njqamcweflpvkl73j4szahhihoc4xt3ktcgjnpaingr5yhkenl5sid.onion",
+				PreviousKeySecret: &v1beta1.SecretReference{Name: "example-key", Key: "private_key"},
+				PreviousHostname:  "25njqamcweflpvkl73j4szahhihoc4xt3ktcgjnpaingr5yhkenl5sid.onion",
+				RetireTime:        &now,
+			},
+			Vanity: &v1beta1.VanityStatus{
+				Prefix:           "example",
+				Phase:            v1beta1.VanitySearching,
+				Attempts:         1000,
+				ExpectedAttempts: 34359738368,
+				StartTime:        &now,
+			},
+			Descriptor: v1beta1.DescriptorStatus{LastPublished: &now, HSDirs: 8},
+			Conditions: []v1beta1.OnionServiceCondition{
+				{Type: v1beta1.OnionServiceReachable, Status: corev1.ConditionFalse, LastTransitionTime: now},
+			},
+		},
+	}
+}
+
+func TestConvertRoundTripFromV1alpha1(t *testing.T) {
+	src := alphaOnionService()
+
+	hub := &v1beta1.OnionService{}
+	if err := src.DeepCopy().ConvertTo(hub); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	for _, annotation := range []string{hubSpecAnnotation, hubStatusAnnotation} {
+		if _, ok := hub.Annotations[annotation]; ok {
+			t.Errorf("v1beta1 object has the %s annotation", annotation)
+		}
+	}
+	if got := hub.Annotations[extraConfigAnnotation]; got != src.Spec.ExtraConfig {
+		t.Errorf("annotation %s = %q, want %q", extraConfigAnnotation, got, src.Spec.ExtraConfig)
+	}
+
+	dst := &OnionService{}
+	if err := dst.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	for _, annotation := range []string{hubSpecAnnotation, hubStatusAnnotation} {
+		if _, ok := dst.Annotations[annotation]; !ok {
+			t.Errorf("v1alpha1 object has no %s annotation", annotation)
+		}
+		delete(dst.Annotations, annotation)
+	}
+
+	if !apiequality.Semantic.DeepEqual(src, dst) {
+		t.Errorf("round trip changed the object:\n%s", diff.ObjectReflectDiff(src, dst))
+	}
+}
+
+func TestConvertRoundTripFromHub(t *testing.T) {
+	tests := []struct {
+		name string
+		// update changes the object while it is read as v1alpha1
+		update func(*OnionService)
+		want   func(*v1beta1.OnionService)
+	}{
+		{
+			name: "unchanged",
+		},
+		{
+			name: "extra config",
+			update: func(o *OnionService) {
+				o.Spec.ExtraConfig = "HiddenServiceMaxStreams 10"
+			},
+			want: func(o *v1beta1.OnionService) {
+				o.Annotations = map[string]string{extraConfigAnnotation: "HiddenServiceMaxStreams 10"}
+			},
+		},
+		{
+			name: "ports changed",
+			update: func(o *OnionService) {
+				o.Spec.Ports = append(o.Spec.Ports, ServicePort{Name: "https", PublicPort: 443, TargetPort: 8443})
+				o.Spec.Ports[1].TargetPort = 9091
+			},
+			want: func(o *v1beta1.OnionService) {
+				o.Spec.Backend.Ports[1].TargetPort = intstr.FromInt(9091)
+				o.Spec.Backend.Ports = append(o.Spec.Backend.Ports, v1beta1.ServicePort{
+					Name: "https", PublicPort: 443, TargetPort: intstr.FromInt(8443),
+				})
+			},
+		},
+		{
+			name: "named port replaced",
+			update: func(o *OnionService) {
+				o.Spec.Ports[0].TargetPort = 8080
+			},
+			want: func(o *v1beta1.OnionService) {
+				o.Spec.Backend.Ports[0].TargetPort = intstr.FromInt(8080)
+			},
+		},
+		{
+			// a status update of a v1alpha1 client keeps the progress of
+			// the migration, rotation and vanity search
+			name: "status updated",
+			update: func(o *OnionService) {
+				o.Status.Hostname = ""
+				o.Status.Descriptor = DescriptorStatus{HSDirs: 6, FailedHSDirs: 2}
+			},
+			want: func(o *v1beta1.OnionService) {
+				o.Status.Hostname = ""
+				o.Status.Descriptor = v1beta1.DescriptorStatus{HSDirs: 6, FailedHSDirs: 2}
+			},
+		},
+		{
+			name: "private key removed",
+			update: func(o *OnionService) {
+				o.Spec.PrivateKeySecret = SecretReference{}
+			},
+			want: func(o *v1beta1.OnionService) {
+				o.Spec.PrivateKeySecret = nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := hubOnionService()
+
+			alpha := &OnionService{}
+			if err := alpha.ConvertFrom(src.DeepCopy()); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+			if tt.update != nil {
+				tt.update(alpha)
+			}
+
+			dst := &v1beta1.OnionService{}
+			if err := alpha.ConvertTo(dst); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+			for _, annotation := range []string{hubSpecAnnotation, hubStatusAnnotation} {
+				if _, ok := dst.Annotations[annotation]; ok {
+					t.Errorf("v1beta1 object has the %s annotation", annotation)
+				}
+			}
+			if dst.Annotations != nil && len(dst.Annotations) == 0 {
+				t.Errorf("v1beta1 object has empty annotations instead of none")
+			}
+
+			want := src.DeepCopy()
+			if tt.want != nil {
+				tt.want(want)
+			}
+			if !apiequality.Semantic.DeepEqual(want, dst) {
+				t.Errorf("round trip changed the object:\n%s", diff.ObjectReflectDiff(want, dst))
+			}
+		})
+	}
+}
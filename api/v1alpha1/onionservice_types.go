@@ -17,6 +17,8 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -35,8 +37,220 @@ type OnionServiceSpec struct {
 	// +kubebuilder:validation:Enum=2;3
 	Version int `json:"version"`
 
+	// Mode selects between a regular anonymous onion service and a single
+	// onion service, which connects directly to introduction points and
+	// rendezvous points for lower latency but does not hide the location
+	// of the server. Defaults to anonymous.
+	// +kubebuilder:validation:Enum=anonymous;singleOnion
+	// +optional
+	Mode OnionServiceMode `json:"mode,omitempty"`
+
+	// Deprecated: ExtraConfig is not rendered into the torrc, use
+	// TorOptions instead.
 	// +optional
 	ExtraConfig string `json:"extraConfig,omitempty"`
+
+	// TorOptions holds commonly tuned options of the tor daemon.
+	// +optional
+	TorOptions *TorOptions `json:"torOptions,omitempty"`
+
+	// SelfTest enables periodic reachability checks of the published onion
+	// address through a local SocksPort of the tor daemon.
+	// +optional
+	SelfTest *SelfTestSpec `json:"selfTest,omitempty"`
+
+	// DoSDefense configures the denial of service defenses of the hidden
+	// service.
+	// +optional
+	DoSDefense *DoSDefenseSpec `json:"dosDefense,omitempty"`
+
+	// OnionLocation advertises the onion on the clearnet version of the
+	// site by adding an Onion-Location header to the responses of an
+	// Ingress.
+	// +optional
+	OnionLocation *OnionLocationSpec `json:"onionLocation,omitempty"`
+
+	// HostnameTarget publishes status.hostname into a ConfigMap or Secret,
+	// so pods can consume the address without access to OnionServices.
+	// +optional
+	HostnameTarget *HostnameTargetSpec `json:"hostnameTarget,omitempty"`
+
+	// Pool is the name of a TorDaemonPool in the same namespace that hosts
+	// the hidden service, instead of a tor daemon of its own.
+	// +optional
+	Pool string `json:"pool,omitempty"`
+}
+
+// HostnameTargetSpec references the key a hostname is written to. The
+// object is created if it does not exist, other keys are left untouched.
+type HostnameTargetSpec struct {
+	// Kind of the object, ConfigMap or Secret.
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+
+	// Name of the object in the namespace of the OnionService.
+	Name string `json:"name"`
+
+	// Key the hostname is stored under, defaults to hostname.
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+const (
+	// HostnameTargetConfigMap writes the hostname into a ConfigMap.
+	HostnameTargetConfigMap = "ConfigMap"
+	// HostnameTargetSecret writes the hostname into a Secret.
+	HostnameTargetSecret = "Secret"
+	// DefaultHostnameTargetKey is used when no key is set.
+	DefaultHostnameTargetKey = "hostname"
+)
+
+// OnionLocationSpec references the Ingress serving the clearnet version of
+// an OnionService. The header is kept up to date with status.hostname.
+type OnionLocationSpec struct {
+	// IngressName is the name of a networking Ingress in the namespace of
+	// the OnionService.
+	IngressName string `json:"ingressName"`
+
+	// IngressClass selects the annotation format understood by the ingress
+	// controller serving the Ingress.
+	// +kubebuilder:validation:Enum=nginx;traefik
+	IngressClass string `json:"ingressClass"`
+}
+
+const (
+	// IngressClassNginx uses a configuration snippet of ingress-nginx.
+	IngressClassNginx = "nginx"
+	// IngressClassTraefik uses the custom response headers of Traefik 1.x.
+	IngressClassTraefik = "traefik"
+)
+
+// DoSDefenseSpec maps to the HiddenServicePoW*, HiddenServiceEnableIntroDoS*
+// and HiddenServiceMaxStreams* options of tor. Unset values keep the
+// defaults of tor.
+type DoSDefenseSpec struct {
+	// PoWDefensesEnabled makes clients solve a proof-of-work puzzle when
+	// the service is under load. Requires tor 0.4.8 or newer.
+	// +optional
+	PoWDefensesEnabled bool `json:"powDefensesEnabled,omitempty"`
+
+	// PoWQueueRate is the number of queued introduction requests handled
+	// per second.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PoWQueueRate *int32 `json:"powQueueRate,omitempty"`
+
+	// PoWQueueBurst is the number of queued introduction requests that can
+	// be handled at once.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PoWQueueBurst *int32 `json:"powQueueBurst,omitempty"`
+
+	// IntroDoSDefenseEnabled asks the introduction points to rate limit
+	// introduction requests sent to the service.
+	// +optional
+	IntroDoSDefenseEnabled bool `json:"introDoSDefenseEnabled,omitempty"`
+
+	// IntroDoSRatePerSec is the allowed rate of introduction requests per
+	// second at each introduction point.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	IntroDoSRatePerSec *int32 `json:"introDoSRatePerSec,omitempty"`
+
+	// IntroDoSBurstPerSec is the allowed burst of introduction requests per
+	// second at each introduction point. Must not be lower than the rate.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	IntroDoSBurstPerSec *int32 `json:"introDoSBurstPerSec,omitempty"`
+
+	// MaxStreams is the maximum number of simultaneous streams per
+	// rendezvous circuit, 0 means unlimited.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	MaxStreams *int32 `json:"maxStreams,omitempty"`
+
+	// MaxStreamsCloseCircuit closes the whole circuit instead of only
+	// refusing the stream when MaxStreams is exceeded.
+	// +optional
+	MaxStreamsCloseCircuit bool `json:"maxStreamsCloseCircuit,omitempty"`
+}
+
+// TorOptions are typed tor options. Unset options keep the defaults of tor.
+type TorOptions struct {
+	// NumIntroductionPoints is the number of introduction points the
+	// hidden service establishes.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=20
+	// +optional
+	NumIntroductionPoints *int32 `json:"numIntroductionPoints,omitempty"`
+
+	// MaxStreams is the maximum number of simultaneous streams per
+	// rendezvous circuit, 0 means unlimited. Conflicts with
+	// dosDefense.maxStreams.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	MaxStreams *int32 `json:"maxStreams,omitempty"`
+
+	// ExportCircuitID makes tor announce the circuit of each connection to
+	// the backend. With haproxy every connection to the backend starts with
+	// a PROXY protocol v1 header carrying the circuit ID in the source
+	// address, so all backend ports have to accept the PROXY protocol and
+	// be named proxy or proxy-<name> to confirm it. Requires version 3.
+	// +kubebuilder:validation:Enum=haproxy;none
+	// +optional
+	ExportCircuitID string `json:"exportCircuitID,omitempty"`
+
+	// BandwidthRate is the average number of bytes per second tor may use.
+	// +optional
+	BandwidthRate *resource.Quantity `json:"bandwidthRate,omitempty"`
+
+	// BandwidthBurst is the maximum number of bytes per second tor may use
+	// in bursts. Must not be lower than BandwidthRate.
+	// +optional
+	BandwidthBurst *resource.Quantity `json:"bandwidthBurst,omitempty"`
+
+	// LogLevel is the minimum severity tor logs to stdout.
+	// +kubebuilder:validation:Enum=debug;info;notice;warn;err
+	// +optional
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// ConnectionPadding controls padding of connections to relays against
+	// traffic analysis.
+	// +kubebuilder:validation:Enum=auto;on;off
+	// +optional
+	ConnectionPadding string `json:"connectionPadding,omitempty"`
+}
+
+const (
+	// ExportCircuitIDHAProxy sends a PROXY protocol v1 header to backends.
+	ExportCircuitIDHAProxy = "haproxy"
+	// ExportCircuitIDNone does not export circuit IDs, the default.
+	ExportCircuitIDNone = "none"
+)
+
+// OnionServiceMode is a valid value for OnionServiceSpec.Mode
+type OnionServiceMode string
+
+const (
+	// AnonymousMode hides the location of the server, the default.
+	AnonymousMode OnionServiceMode = "anonymous"
+	// SingleOnionMode trades server anonymity for lower latency.
+	SingleOnionMode OnionServiceMode = "singleOnion"
+)
+
+// SelfTestSpec configures the reachability checks of an OnionService.
+type SelfTestSpec struct {
+	// Interval between two checks, defaults to 5m.
+	// +optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+
+	// Protocol used to check each public port. TCP only opens a connection,
+	// HTTP sends a GET request and accepts any response. Defaults to TCP.
+	// +kubebuilder:validation:Enum=TCP;HTTP
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
 }
 
 type ServicePort struct {
@@ -74,6 +288,67 @@ type SecretReference struct {
 type OnionServiceStatus struct {
 	Hostname        string `json:"hostname"`
 	TargetClusterIP string `json:"targetClusterIP"`
+
+	// Descriptor reports the last publication of the hidden service
+	// descriptor to the HSDirs.
+	// +optional
+	Descriptor DescriptorStatus `json:"descriptor,omitempty"`
+
+	// Conditions represent the latest available observations of the
+	// OnionService.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []OnionServiceCondition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// OnionServiceConditionType is a valid value for OnionServiceCondition.Type
+type OnionServiceConditionType string
+
+const (
+	// OnionServiceReachable means the last self-test reached the onion on
+	// all public ports.
+	OnionServiceReachable OnionServiceConditionType = "Reachable"
+)
+
+// OnionServiceCondition describes the state of an OnionService at a certain
+// point.
+type OnionServiceCondition struct {
+	// Type of the condition.
+	Type OnionServiceConditionType `json:"type"`
+
+	// Status of the condition, one of True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+
+	// The last time the condition transitioned from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// The reason for the condition's last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// A human readable message indicating details about the transition.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// DescriptorStatus describes the last upload round of the hidden service
+// descriptor as reported by HS_DESC events of the tor daemon.
+type DescriptorStatus struct {
+	// LastPublished is the time an HSDir last accepted the descriptor.
+	// +optional
+	LastPublished *metav1.Time `json:"lastPublished,omitempty"`
+
+	// HSDirs is the number of HSDirs that accepted the descriptor in the
+	// last upload round.
+	// +optional
+	HSDirs int `json:"hsDirs,omitempty"`
+
+	// FailedHSDirs is the number of HSDirs the descriptor could not be
+	// uploaded to in the last upload round.
+	// +optional
+	FailedHSDirs int `json:"failedHSDirs,omitempty"`
 }
 
 // +kubebuilder:object:root=true
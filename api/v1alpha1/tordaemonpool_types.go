@@ -0,0 +1,67 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TorDaemonPoolSpec defines the desired state of TorDaemonPool. Members join
+// a pool by setting spec.pool on the OnionService.
+type TorDaemonPoolSpec struct {
+	// ControlPassword protects the control port of the pooled tor daemon
+	// with a password generated at startup instead of cookie
+	// authentication.
+	// +optional
+	ControlPassword bool `json:"controlPassword,omitempty"`
+}
+
+// TorDaemonPoolStatus defines the observed state of TorDaemonPool
+type TorDaemonPoolStatus struct {
+	// Members are the names of the OnionServices hosted by the pool.
+	// +optional
+	Members []string `json:"members,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// TorDaemonPool runs a single tor daemon hosting the hidden services of many
+// OnionServices.
+// +genclient
+// +k8s:deepcopy-gen=true
+// +kubebuilder:resource:path=tordaemonpools
+type TorDaemonPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TorDaemonPoolSpec   `json:"spec,omitempty"`
+	Status TorDaemonPoolStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TorDaemonPoolList contains a list of TorDaemonPool
+type TorDaemonPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TorDaemonPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TorDaemonPool{}, &TorDaemonPoolList{})
+}
@@ -24,13 +24,102 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DescriptorStatus) DeepCopyInto(out *DescriptorStatus) {
+	*out = *in
+	if in.LastPublished != nil {
+		in, out := &in.LastPublished, &out.LastPublished
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DescriptorStatus.
+func (in *DescriptorStatus) DeepCopy() *DescriptorStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DescriptorStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DoSDefenseSpec) DeepCopyInto(out *DoSDefenseSpec) {
+	*out = *in
+	if in.PoWQueueRate != nil {
+		in, out := &in.PoWQueueRate, &out.PoWQueueRate
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PoWQueueBurst != nil {
+		in, out := &in.PoWQueueBurst, &out.PoWQueueBurst
+		*out = new(int32)
+		**out = **in
+	}
+	if in.IntroDoSRatePerSec != nil {
+		in, out := &in.IntroDoSRatePerSec, &out.IntroDoSRatePerSec
+		*out = new(int32)
+		**out = **in
+	}
+	if in.IntroDoSBurstPerSec != nil {
+		in, out := &in.IntroDoSBurstPerSec, &out.IntroDoSBurstPerSec
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxStreams != nil {
+		in, out := &in.MaxStreams, &out.MaxStreams
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DoSDefenseSpec.
+func (in *DoSDefenseSpec) DeepCopy() *DoSDefenseSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DoSDefenseSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostnameTargetSpec) DeepCopyInto(out *HostnameTargetSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostnameTargetSpec.
+func (in *HostnameTargetSpec) DeepCopy() *HostnameTargetSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HostnameTargetSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionLocationSpec) DeepCopyInto(out *OnionLocationSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionLocationSpec.
+func (in *OnionLocationSpec) DeepCopy() *OnionLocationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionLocationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OnionService) DeepCopyInto(out *OnionService) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionService.
@@ -51,6 +140,22 @@ func (in *OnionService) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OnionServiceCondition) DeepCopyInto(out *OnionServiceCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServiceCondition.
+func (in *OnionServiceCondition) DeepCopy() *OnionServiceCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(OnionServiceCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OnionServiceList) DeepCopyInto(out *OnionServiceList) {
 	*out = *in
@@ -99,6 +204,31 @@ func (in *OnionServiceSpec) DeepCopyInto(out *OnionServiceSpec) {
 		}
 	}
 	out.PrivateKeySecret = in.PrivateKeySecret
+	if in.SelfTest != nil {
+		in, out := &in.SelfTest, &out.SelfTest
+		*out = new(SelfTestSpec)
+		**out = **in
+	}
+	if in.TorOptions != nil {
+		in, out := &in.TorOptions, &out.TorOptions
+		*out = new(TorOptions)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DoSDefense != nil {
+		in, out := &in.DoSDefense, &out.DoSDefense
+		*out = new(DoSDefenseSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OnionLocation != nil {
+		in, out := &in.OnionLocation, &out.OnionLocation
+		*out = new(OnionLocationSpec)
+		**out = **in
+	}
+	if in.HostnameTarget != nil {
+		in, out := &in.HostnameTarget, &out.HostnameTarget
+		*out = new(HostnameTargetSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServiceSpec.
@@ -114,6 +244,14 @@ func (in *OnionServiceSpec) DeepCopy() *OnionServiceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OnionServiceStatus) DeepCopyInto(out *OnionServiceStatus) {
 	*out = *in
+	in.Descriptor.DeepCopyInto(&out.Descriptor)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]OnionServiceCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OnionServiceStatus.
@@ -141,6 +279,22 @@ func (in *SecretReference) DeepCopy() *SecretReference {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SelfTestSpec) DeepCopyInto(out *SelfTestSpec) {
+	*out = *in
+	out.Interval = in.Interval
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SelfTestSpec.
+func (in *SelfTestSpec) DeepCopy() *SelfTestSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SelfTestSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServicePort) DeepCopyInto(out *ServicePort) {
 	*out = *in
@@ -155,3 +309,132 @@ func (in *ServicePort) DeepCopy() *ServicePort {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorDaemonPool) DeepCopyInto(out *TorDaemonPool) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorDaemonPool.
+func (in *TorDaemonPool) DeepCopy() *TorDaemonPool {
+	if in == nil {
+		return nil
+	}
+	out := new(TorDaemonPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorDaemonPool) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorDaemonPoolList) DeepCopyInto(out *TorDaemonPoolList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TorDaemonPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorDaemonPoolList.
+func (in *TorDaemonPoolList) DeepCopy() *TorDaemonPoolList {
+	if in == nil {
+		return nil
+	}
+	out := new(TorDaemonPoolList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TorDaemonPoolList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorDaemonPoolSpec) DeepCopyInto(out *TorDaemonPoolSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorDaemonPoolSpec.
+func (in *TorDaemonPoolSpec) DeepCopy() *TorDaemonPoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TorDaemonPoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorDaemonPoolStatus) DeepCopyInto(out *TorDaemonPoolStatus) {
+	*out = *in
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorDaemonPoolStatus.
+func (in *TorDaemonPoolStatus) DeepCopy() *TorDaemonPoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TorDaemonPoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TorOptions) DeepCopyInto(out *TorOptions) {
+	*out = *in
+	if in.NumIntroductionPoints != nil {
+		in, out := &in.NumIntroductionPoints, &out.NumIntroductionPoints
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxStreams != nil {
+		in, out := &in.MaxStreams, &out.MaxStreams
+		*out = new(int32)
+		**out = **in
+	}
+	if in.BandwidthRate != nil {
+		in, out := &in.BandwidthRate, &out.BandwidthRate
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.BandwidthBurst != nil {
+		in, out := &in.BandwidthBurst, &out.BandwidthBurst
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TorOptions.
+func (in *TorOptions) DeepCopy() *TorOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(TorOptions)
+	in.DeepCopyInto(out)
+	return out
+}
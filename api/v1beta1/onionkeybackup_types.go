@@ -0,0 +1,150 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OnionKeyBackupSpec defines the desired state of OnionKeyBackup. The keys of
+// all OnionServices in the namespace of the backup are exported.
+type OnionKeyBackupSpec struct {
+	// Interval between two backups, defaults to 24h.
+	// +optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+
+	// RecipientsSecret holds the armored OpenPGP public keys the bundles are
+	// encrypted to.
+	RecipientsSecret SecretReference `json:"recipientsSecret"`
+
+	// Target the encrypted bundles are written to.
+	Target BackupTarget `json:"target"`
+
+	// Restore recreates the key Secrets from a bundle in the target.
+	// +optional
+	Restore *RestoreSpec `json:"restore,omitempty"`
+}
+
+// BackupTarget is where bundles are stored. Exactly one of the targets has
+// to be set.
+type BackupTarget struct {
+	// PersistentVolumeClaim stores bundles on a claim mounted into the
+	// operator.
+	// +optional
+	PersistentVolumeClaim *PersistentVolumeClaimTarget `json:"persistentVolumeClaim,omitempty"`
+
+	// S3 stores bundles in a bucket of an S3 compatible object store.
+	// +optional
+	S3 *S3Target `json:"s3,omitempty"`
+}
+
+// PersistentVolumeClaimTarget stores bundles on a PersistentVolumeClaim. The
+// claim has to be mounted into the operator at
+// /var/lib/tor-operator/backups/<claimName>.
+type PersistentVolumeClaimTarget struct {
+	// ClaimName of the PersistentVolumeClaim.
+	ClaimName string `json:"claimName"`
+
+	// Path of the directory on the claim, defaults to its root.
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// S3Target stores bundles in a bucket of an S3 compatible object store.
+type S3Target struct {
+	// Endpoint of the object store, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://minio.minio:9000.
+	Endpoint string `json:"endpoint"`
+
+	// Bucket the bundles are stored in.
+	Bucket string `json:"bucket"`
+
+	// Prefix of the object names.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Region of the bucket, defaults to us-east-1.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// CredentialsSecret holds the accessKeyID and secretAccessKey used to
+	// sign requests.
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// RestoreSpec selects the bundle to restore.
+type RestoreSpec struct {
+	// Bundle is the name of the bundle in the target.
+	Bundle string `json:"bundle"`
+
+	// PrivateKeySecret holds the armored OpenPGP private key of a recipient
+	// of the bundle. A passphrase protecting the key is read from the key
+	// passphrase of the same Secret.
+	PrivateKeySecret SecretReference `json:"privateKeySecret"`
+}
+
+// OnionKeyBackupStatus defines the observed state of OnionKeyBackup
+type OnionKeyBackupStatus struct {
+	// LastBackup is the name of the last bundle written.
+	// +optional
+	LastBackup string `json:"lastBackup,omitempty"`
+
+	// LastBackupTime is when the last bundle was written.
+	// +optional
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+
+	// OnionServices is the number of OnionServices in the last bundle.
+	// +optional
+	OnionServices int `json:"onionServices,omitempty"`
+
+	// RestoredBundle is the name of the last bundle restored.
+	// +optional
+	RestoredBundle string `json:"restoredBundle,omitempty"`
+
+	// Message explains the last failure.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// OnionKeyBackup periodically exports the keys of the OnionServices in its
+// namespace into an encrypted bundle and restores them.
+// +genclient
+// +k8s:deepcopy-gen=true
+// +kubebuilder:resource:path=onionkeybackups
+type OnionKeyBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OnionKeyBackupSpec   `json:"spec,omitempty"`
+	Status OnionKeyBackupStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// OnionKeyBackupList contains a list of OnionKeyBackup
+type OnionKeyBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OnionKeyBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OnionKeyBackup{}, &OnionKeyBackupList{})
+}
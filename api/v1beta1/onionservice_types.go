@@ -0,0 +1,648 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// OnionServiceSpec defines the desired state of OnionService
+type OnionServiceSpec struct {
+	// +kubebuilder:validation:Enum=2;3
+	Version int `json:"version"`
+
+	// Mode selects between a regular anonymous onion service and a single
+	// onion service, which connects directly to introduction points and
+	// rendezvous points for lower latency but does not hide the location
+	// of the server. Defaults to anonymous.
+	// +kubebuilder:validation:Enum=anonymous;singleOnion
+	// +optional
+	Mode OnionServiceMode `json:"mode,omitempty"`
+
+	// Backend selects the pods the onion forwards to.
+	Backend BackendSpec `json:"backend"`
+
+	// PrivateKeySecret holds the private key of the onion. Without a
+	// private key tor generates a new one. Version 3 keys are accepted as
+	// hs_ed25519_secret_key file, 64 byte expanded key, 64 byte ed25519
+	// private key, 32 byte seed or ED25519-V3:<base64> key of ADD_ONION.
+	// +optional
+	PrivateKeySecret *SecretReference `json:"privateKeySecret,omitempty"`
+
+	// TorOptions holds commonly tuned options of the tor daemon.
+	// +optional
+	TorOptions *TorOptions `json:"torOptions,omitempty"`
+
+	// SelfTest enables periodic reachability checks of the published onion
+	// address through a local SocksPort of the tor daemon.
+	// +optional
+	SelfTest *SelfTestSpec `json:"selfTest,omitempty"`
+
+	// DoSDefense configures the denial of service defenses of the hidden
+	// service.
+	// +optional
+	DoSDefense *DoSDefenseSpec `json:"dosDefense,omitempty"`
+
+	// OnionLocation advertises the onion on the clearnet version of the
+	// site by adding an Onion-Location header to the responses of an
+	// Ingress.
+	// +optional
+	OnionLocation *OnionLocationSpec `json:"onionLocation,omitempty"`
+
+	// HostnameTarget publishes status.hostname into a ConfigMap or Secret,
+	// so pods can consume the address without access to OnionServices.
+	// +optional
+	HostnameTarget *HostnameTargetSpec `json:"hostnameTarget,omitempty"`
+
+	// Pool is the name of a TorDaemonPool in the same namespace that hosts
+	// the hidden service, instead of a tor daemon of its own.
+	// +optional
+	Pool string `json:"pool,omitempty"`
+
+	// Migration runs a version 3 onion with a generated key in place of a
+	// version 2 onion, which tor no longer serves. Set version to 3 to make
+	// the new address permanent.
+	// +optional
+	Migration *MigrationSpec `json:"migration,omitempty"`
+
+	// KeyRotation replaces the key of a version 3 onion with a generated
+	// one whenever its token changes. The generated key takes precedence
+	// over PrivateKeySecret and is kept after KeyRotation is removed.
+	// +optional
+	KeyRotation *KeyRotationSpec `json:"keyRotation,omitempty"`
+
+	// VanityPrefix makes the operator search for a key whose version 3
+	// address starts with the prefix. The Deployment is only rolled out
+	// once a key is found, which then takes precedence over
+	// PrivateKeySecret. After a change of the prefix the previous key is
+	// served until the new one is found. Every character multiplies the
+	// search time by 32.
+	// +kubebuilder:validation:MaxLength=6
+	// +kubebuilder:validation:Pattern=`^[a-z2-7]*$`
+	// +optional
+	VanityPrefix string `json:"vanityPrefix,omitempty"`
+
+	// KeyProvider fetches the private key from an external store when the
+	// daemon starts, instead of reading it from PrivateKeySecret.
+	// +optional
+	KeyProvider *KeyProviderSpec `json:"keyProvider,omitempty"`
+
+	// NetworkPolicy generates NetworkPolicies that restrict the tor daemon
+	// to the Tor network and the backends, and the backends to connections
+	// from the tor daemon.
+	// +optional
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// Rollout configures how the daemon is replaced and evicted.
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+}
+
+// RolloutSpec configures the Deployment and PodDisruptionBudget of the
+// daemon of an OnionService.
+type RolloutSpec struct {
+	// Strategy of the Deployment, defaults to Recreate, which stops the
+	// old daemon before the new one starts. RollingUpdate starts the new
+	// daemon first, so two daemons with the same key briefly race on
+	// publishing the descriptor.
+	// +kubebuilder:validation:Enum=Recreate;RollingUpdate
+	// +optional
+	Strategy appsv1.DeploymentStrategyType `json:"strategy,omitempty"`
+
+	// PodDisruptionBudget protects the daemon from evictions. Node drains
+	// and cluster autoscaler scale-downs then wait until the pod is deleted
+	// by hand.
+	// +optional
+	PodDisruptionBudget bool `json:"podDisruptionBudget,omitempty"`
+}
+
+// NetworkPolicySpec configures the NetworkPolicies of an OnionService.
+type NetworkPolicySpec struct {
+	// PrivateCIDRs are excluded from the egress of the tor daemon to the
+	// Tor network, defaults to 10.0.0.0/8, 172.16.0.0/12 and
+	// 192.168.0.0/16. Must be IPv4 ranges.
+	// +optional
+	PrivateCIDRs []string `json:"privateCIDRs,omitempty"`
+
+	// APIServerCIDRs the daemon manager reports the status to, defaults to
+	// the endpoints of the kubernetes Service in the default namespace.
+	// +optional
+	APIServerCIDRs []string `json:"apiServerCIDRs,omitempty"`
+}
+
+// MigrationSpec configures the migration of a version 2 onion to version 3.
+type MigrationSpec struct {
+	// KeySecretName is the Secret the generated version 3 key is stored
+	// in, defaults to the name of the OnionService suffixed with -v3-key.
+	// The Secret is not deleted along with the OnionService.
+	// +optional
+	KeySecretName string `json:"keySecretName,omitempty"`
+}
+
+// KeyRotationSpec configures the rotation of the key of an onion.
+type KeyRotationSpec struct {
+	// Token triggers a rotation whenever it changes, e.g. the date of the
+	// rotation or a counter.
+	Token string `json:"token"`
+
+	// Overlap is how long the previous key is served next to the new one
+	// before it is retired, defaults to 24h.
+	// +optional
+	Overlap metav1.Duration `json:"overlap,omitempty"`
+}
+
+// KeyProviderSpec selects the external store of the private key. The key is
+// fetched by an init container into memory and never stored in a Secret.
+type KeyProviderSpec struct {
+	// Vault reads the key from a KV secrets engine of HashiCorp Vault.
+	// +optional
+	Vault *VaultKeyProvider `json:"vault,omitempty"`
+}
+
+// VaultKeyProvider reads the private key from a KV secrets engine of
+// HashiCorp Vault.
+type VaultKeyProvider struct {
+	// Address of the Vault server, e.g. https://vault.vault:8200.
+	Address string `json:"address"`
+
+	// Mount of the KV secrets engine, defaults to secret.
+	// +optional
+	Mount string `json:"mount,omitempty"`
+
+	// Path of the secret within the mount.
+	Path string `json:"path"`
+
+	// KVVersion of the secrets engine, defaults to 2.
+	// +kubebuilder:validation:Enum=1;2
+	// +optional
+	KVVersion int `json:"kvVersion,omitempty"`
+
+	// Field of the secret holding the base64 encoded key, defaults to
+	// hs_ed25519_secret_key. Keys in the ED25519-V3:<base64> format are
+	// read as is.
+	// +optional
+	Field string `json:"field,omitempty"`
+
+	// Role logs in with the Kubernetes auth method of Vault using the
+	// service account of the daemon.
+	// +optional
+	Role string `json:"role,omitempty"`
+
+	// AuthPath is the mount of the Kubernetes auth method, defaults to
+	// kubernetes.
+	// +optional
+	AuthPath string `json:"authPath,omitempty"`
+
+	// TokenSecret holds a Vault token used instead of logging in with
+	// Role.
+	// +optional
+	TokenSecret *SecretReference `json:"tokenSecret,omitempty"`
+}
+
+// BackendSpec selects the pods behind an onion and the ports exposed on it.
+type BackendSpec struct {
+	// Selector of the pods traffic is forwarded to.
+	Selector map[string]string `json:"selector"`
+
+	// The list of ports that are exposed by this service.
+	// +patchMergeKey=publicPort
+	// +patchStrategy=merge
+	Ports []ServicePort `json:"ports" patchStrategy:"merge" patchMergeKey:"publicPort"`
+}
+
+// HostnameTargetSpec references the key a hostname is written to. The
+// object is created if it does not exist, other keys are left untouched.
+type HostnameTargetSpec struct {
+	// Kind of the object, ConfigMap or Secret.
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+
+	// Name of the object in the namespace of the OnionService.
+	Name string `json:"name"`
+
+	// Key the hostname is stored under, defaults to hostname.
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+const (
+	// HostnameTargetConfigMap writes the hostname into a ConfigMap.
+	HostnameTargetConfigMap = "ConfigMap"
+	// HostnameTargetSecret writes the hostname into a Secret.
+	HostnameTargetSecret = "Secret"
+	// DefaultHostnameTargetKey is used when no key is set.
+	DefaultHostnameTargetKey = "hostname"
+)
+
+// OnionLocationSpec references the Ingress serving the clearnet version of
+// an OnionService. The header is kept up to date with status.hostname.
+type OnionLocationSpec struct {
+	// IngressName is the name of a networking Ingress in the namespace of
+	// the OnionService.
+	IngressName string `json:"ingressName"`
+
+	// IngressClass selects the annotation format understood by the ingress
+	// controller serving the Ingress.
+	// +kubebuilder:validation:Enum=nginx;traefik
+	IngressClass string `json:"ingressClass"`
+}
+
+const (
+	// IngressClassNginx uses a configuration snippet of ingress-nginx.
+	IngressClassNginx = "nginx"
+	// IngressClassTraefik uses the custom response headers of Traefik 1.x.
+	IngressClassTraefik = "traefik"
+)
+
+// DoSDefenseSpec maps to the HiddenServicePoW*, HiddenServiceEnableIntroDoS*
+// and HiddenServiceMaxStreams* options of tor. Unset values keep the
+// defaults of tor.
+type DoSDefenseSpec struct {
+	// PoWDefensesEnabled makes clients solve a proof-of-work puzzle when
+	// the service is under load. Requires tor 0.4.8 or newer.
+	// +optional
+	PoWDefensesEnabled bool `json:"powDefensesEnabled,omitempty"`
+
+	// PoWQueueRate is the number of queued introduction requests handled
+	// per second.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PoWQueueRate *int32 `json:"powQueueRate,omitempty"`
+
+	// PoWQueueBurst is the number of queued introduction requests that can
+	// be handled at once.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PoWQueueBurst *int32 `json:"powQueueBurst,omitempty"`
+
+	// IntroDoSDefenseEnabled asks the introduction points to rate limit
+	// introduction requests sent to the service.
+	// +optional
+	IntroDoSDefenseEnabled bool `json:"introDoSDefenseEnabled,omitempty"`
+
+	// IntroDoSRatePerSec is the allowed rate of introduction requests per
+	// second at each introduction point.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	IntroDoSRatePerSec *int32 `json:"introDoSRatePerSec,omitempty"`
+
+	// IntroDoSBurstPerSec is the allowed burst of introduction requests per
+	// second at each introduction point. Must not be lower than the rate.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	IntroDoSBurstPerSec *int32 `json:"introDoSBurstPerSec,omitempty"`
+
+	// MaxStreams is the maximum number of simultaneous streams per
+	// rendezvous circuit, 0 means unlimited.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	MaxStreams *int32 `json:"maxStreams,omitempty"`
+
+	// MaxStreamsCloseCircuit closes the whole circuit instead of only
+	// refusing the stream when MaxStreams is exceeded.
+	// +optional
+	MaxStreamsCloseCircuit bool `json:"maxStreamsCloseCircuit,omitempty"`
+}
+
+// TorOptions are typed tor options. Unset options keep the defaults of tor.
+type TorOptions struct {
+	// NumIntroductionPoints is the number of introduction points the
+	// hidden service establishes.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=20
+	// +optional
+	NumIntroductionPoints *int32 `json:"numIntroductionPoints,omitempty"`
+
+	// MaxStreams is the maximum number of simultaneous streams per
+	// rendezvous circuit, 0 means unlimited. Conflicts with
+	// dosDefense.maxStreams.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	MaxStreams *int32 `json:"maxStreams,omitempty"`
+
+	// ExportCircuitID makes tor announce the circuit of each connection to
+	// the backend. With haproxy every connection to the backend starts with
+	// a PROXY protocol v1 header carrying the circuit ID in the source
+	// address, so all backend ports have to accept the PROXY protocol and
+	// be named proxy or proxy-<name> to confirm it. Requires version 3.
+	// +kubebuilder:validation:Enum=haproxy;none
+	// +optional
+	ExportCircuitID string `json:"exportCircuitID,omitempty"`
+
+	// BandwidthRate is the average number of bytes per second tor may use.
+	// +optional
+	BandwidthRate *resource.Quantity `json:"bandwidthRate,omitempty"`
+
+	// BandwidthBurst is the maximum number of bytes per second tor may use
+	// in bursts. Must not be lower than BandwidthRate.
+	// +optional
+	BandwidthBurst *resource.Quantity `json:"bandwidthBurst,omitempty"`
+
+	// LogLevel is the minimum severity tor logs to stdout.
+	// +kubebuilder:validation:Enum=debug;info;notice;warn;err
+	// +optional
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// ConnectionPadding controls padding of connections to relays against
+	// traffic analysis.
+	// +kubebuilder:validation:Enum=auto;on;off
+	// +optional
+	ConnectionPadding string `json:"connectionPadding,omitempty"`
+}
+
+const (
+	// ExportCircuitIDHAProxy sends a PROXY protocol v1 header to backends.
+	ExportCircuitIDHAProxy = "haproxy"
+	// ExportCircuitIDNone does not export circuit IDs, the default.
+	ExportCircuitIDNone = "none"
+)
+
+// OnionServiceMode is a valid value for OnionServiceSpec.Mode
+type OnionServiceMode string
+
+const (
+	// AnonymousMode hides the location of the server, the default.
+	AnonymousMode OnionServiceMode = "anonymous"
+	// SingleOnionMode trades server anonymity for lower latency.
+	SingleOnionMode OnionServiceMode = "singleOnion"
+)
+
+// SelfTestSpec configures the reachability checks of an OnionService.
+type SelfTestSpec struct {
+	// Interval between two checks, defaults to 5m.
+	// +optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+
+	// Protocol used to check each public port. TCP only opens a connection,
+	// HTTP sends a GET request and accepts any response. Defaults to TCP.
+	// +kubebuilder:validation:Enum=TCP;HTTP
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+}
+
+type ServicePort struct {
+	// Optional if only one ServicePort is defined on this service.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// The port that will be exposed by this service.
+	PublicPort int32 `json:"publicPort"`
+
+	// Number or name of the port to access on the pods targeted by the service.
+	// Number must be in the range 1 to 65535. Name must be an IANA_SVC_NAME.
+	// If this is a string, it will be looked up as a named port in the
+	// target Pod's container ports. If this is not specified, the value
+	// of the 'publicPort' field is used (an identity map).
+	// More info: https://kubernetes.io/docs/concepts/services-networking/service/#defining-a-service
+	// +optional
+	TargetPort intstr.IntOrString `json:"targetPort,omitempty"`
+}
+
+// BackendPort returns the port of the generated Service that forwards to
+// TargetPort. Named and unset target ports are exposed on the public port.
+func (p ServicePort) BackendPort() int32 {
+	if p.TargetPort.Type == intstr.Int && p.TargetPort.IntVal != 0 {
+		return p.TargetPort.IntVal
+	}
+	return p.PublicPort
+}
+
+// BackendTargetPort returns the target port of the generated Service.
+func (p ServicePort) BackendTargetPort() intstr.IntOrString {
+	if p.TargetPort.Type == intstr.String && p.TargetPort.StrVal != "" {
+		return p.TargetPort
+	}
+	return intstr.FromInt(int(p.BackendPort()))
+}
+
+// SecretReference represents a Secret Reference
+type SecretReference struct {
+	// Name is unique within a namespace to reference a secret resource.
+	Name string `json:"name"`
+
+	// Key of the private key in the secret.
+	Key string `json:"key"`
+}
+
+// OnionServiceStatus defines the observed state of OnionService
+type OnionServiceStatus struct {
+	Hostname        string `json:"hostname"`
+	TargetClusterIP string `json:"targetClusterIP"`
+
+	// ExpectedHostname is the address derived from the private key, known
+	// before the daemon publishes the hostname.
+	// +optional
+	ExpectedHostname string `json:"expectedHostname,omitempty"`
+
+	// MigrationHostname is the version 3 address served in place of a
+	// version 2 onion while it is migrated.
+	// +optional
+	MigrationHostname string `json:"migrationHostname,omitempty"`
+
+	// OnionLocation is the Ingress the Onion-Location header was added to,
+	// so it is removed again once spec.onionLocation changes.
+	// +optional
+	OnionLocation *OnionLocationSpec `json:"onionLocation,omitempty"`
+
+	// KeyRotation reports the last key rotation.
+	// +optional
+	KeyRotation *KeyRotationStatus `json:"keyRotation,omitempty"`
+
+	// Vanity reports the search for a key matching the vanity prefix.
+	// +optional
+	Vanity *VanityStatus `json:"vanity,omitempty"`
+
+	// Descriptor reports the last publication of the hidden service
+	// descriptor to the HSDirs.
+	// +optional
+	Descriptor DescriptorStatus `json:"descriptor,omitempty"`
+
+	// Conditions represent the latest available observations of the
+	// OnionService.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []OnionServiceCondition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// KeyRotationStatus reports the keys of a rotation.
+type KeyRotationStatus struct {
+	// Token of the last rotation.
+	Token string `json:"token"`
+
+	// KeySecret holds the key generated by the last rotation. The Secret is
+	// not deleted along with the OnionService.
+	KeySecret SecretReference `json:"keySecret"`
+
+	// Hostname is the address of the generated key.
+	Hostname string `json:"hostname"`
+
+	// PreviousKeySecret holds the key served next to the generated one
+	// until RetireTime.
+	// +optional
+	PreviousKeySecret *SecretReference `json:"previousKeySecret,omitempty"`
+
+	// PreviousHostname is the address retired at RetireTime.
+	// +optional
+	PreviousHostname string `json:"previousHostname,omitempty"`
+
+	// RetireTime is when the previous key stops being served.
+	// +optional
+	RetireTime *metav1.Time `json:"retireTime,omitempty"`
+}
+
+// VanityPhase is the state of the search for a vanity key.
+type VanityPhase string
+
+const (
+	// VanitySearching means keys are being generated.
+	VanitySearching VanityPhase = "Searching"
+	// VanityFound means a matching key is stored in the key Secret.
+	VanityFound VanityPhase = "Found"
+	// VanityFailed means the search gave up, change the prefix to retry.
+	VanityFailed VanityPhase = "Failed"
+)
+
+// VanityStatus reports the search for a key matching a vanity prefix.
+type VanityStatus struct {
+	// Prefix searched for.
+	Prefix string `json:"prefix"`
+
+	// Phase of the search.
+	Phase VanityPhase `json:"phase"`
+
+	// Attempts is the number of keys generated so far.
+	// +optional
+	Attempts int64 `json:"attempts,omitempty"`
+
+	// ExpectedAttempts is the average number of keys generated until one
+	// matches the prefix.
+	ExpectedAttempts int64 `json:"expectedAttempts"`
+
+	// StartTime is when the search started.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// EstimatedCompletionTime is when the search is expected to find a key
+	// at the current rate. The search may take considerably longer.
+	// +optional
+	EstimatedCompletionTime *metav1.Time `json:"estimatedCompletionTime,omitempty"`
+
+	// KeySecret holds the vanity key in use, which is the one of the
+	// previous prefix until the search finds a key. The Secret is not
+	// deleted along with the OnionService.
+	// +optional
+	KeySecret *SecretReference `json:"keySecret,omitempty"`
+
+	// Message explains why the search failed.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// OnionServiceConditionType is a valid value for OnionServiceCondition.Type
+type OnionServiceConditionType string
+
+const (
+	// OnionServiceReachable means the last self-test reached the onion on
+	// all public ports.
+	OnionServiceReachable OnionServiceConditionType = "Reachable"
+	// OnionServiceDeprecated means the OnionService uses a feature that no
+	// longer works on the Tor network, like version 2 onions.
+	OnionServiceDeprecated OnionServiceConditionType = "Deprecated"
+	// OnionServiceKeyInvalid means the private key referenced by the
+	// OnionService is missing or not a valid key of its version.
+	OnionServiceKeyInvalid OnionServiceConditionType = "KeyInvalid"
+	// OnionServicePooled means the TorDaemonPool of the OnionService serves
+	// it. It is false while the pool skips the OnionService because of an
+	// invalid spec or key or a pending vanity key.
+	OnionServicePooled OnionServiceConditionType = "Pooled"
+)
+
+// OnionServiceCondition describes the state of an OnionService at a certain
+// point.
+type OnionServiceCondition struct {
+	// Type of the condition.
+	Type OnionServiceConditionType `json:"type"`
+
+	// Status of the condition, one of True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+
+	// The last time the condition transitioned from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// The reason for the condition's last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// A human readable message indicating details about the transition.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// DescriptorStatus describes the last upload round of the hidden service
+// descriptor as reported by HS_DESC events of the tor daemon.
+type DescriptorStatus struct {
+	// LastPublished is the time an HSDir last accepted the descriptor.
+	// +optional
+	LastPublished *metav1.Time `json:"lastPublished,omitempty"`
+
+	// HSDirs is the number of HSDirs that accepted the descriptor in the
+	// last upload round.
+	// +optional
+	HSDirs int `json:"hsDirs,omitempty"`
+
+	// FailedHSDirs is the number of HSDirs the descriptor could not be
+	// uploaded to in the last upload round.
+	// +optional
+	FailedHSDirs int `json:"failedHSDirs,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+
+// OnionService is the Schema for the onionservices API
+// +genclient
+// +k8s:deepcopy-gen=true
+// +kubebuilder:resource:path=onionservices
+type OnionService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OnionServiceSpec   `json:"spec,omitempty"`
+	Status OnionServiceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// OnionServiceList contains a list of OnionService
+type OnionServiceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OnionService `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OnionService{}, &OnionServiceList{})
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"flag"
 	"github.com/marcus-sa/tor-operator/controllers"
+	"github.com/marcus-sa/tor-operator/pkg/metrics"
 	"os"
+	goruntime "runtime"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -13,6 +15,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	torv1alpha1 "github.com/marcus-sa/tor-operator/api/v1alpha1"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -25,16 +28,25 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(torv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(torv1beta1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var vanityWorkers int
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	// leave CPUs to the reconcilers and the health probes
+	defaultVanityWorkers := goruntime.NumCPU() / 2
+	if defaultVanityWorkers < 1 {
+		defaultVanityWorkers = 1
+	}
+	flag.IntVar(&vanityWorkers, "vanity-workers", defaultVanityWorkers,
+		"The number of goroutines searching for vanity keys across all OnionServices.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -54,14 +66,44 @@ func main() {
 	if err = (&controllers.OnionServiceReconciler{
 		Client: mgr.GetClient(),
 		Recorder: mgr.GetEventRecorderFor("OnionServiceController"),
+		VanityWorkers: vanityWorkers,
 		Log:    ctrl.Log.WithName("controllers").WithName("OnionService"),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "OnionService")
 		os.Exit(1)
 	}
+	if err = (&controllers.TorDaemonPoolReconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("TorDaemonPoolController"),
+		Log:      ctrl.Log.WithName("controllers").WithName("TorDaemonPool"),
+		Scheme:   mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TorDaemonPool")
+		os.Exit(1)
+	}
+	if err = (&controllers.OnionKeyBackupReconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("OnionKeyBackupController"),
+		Log:      ctrl.Log.WithName("controllers").WithName("OnionKeyBackup"),
+		Scheme:   mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "OnionKeyBackup")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&torv1beta1.OnionService{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "OnionService")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
+	if err := metrics.RegisterOnionServiceMetrics(mgr.GetClient()); err != nil {
+		setupLog.Error(err, "unable to register OnionService metrics")
+		os.Exit(1)
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/keyprovider"
+	"github.com/marcus-sa/tor-operator/pkg/keys"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fetchKeyTimeout bounds the time the init container waits for the key store.
+const fetchKeyTimeout = 2 * time.Minute
+
+// fetchPrivateKey writes the key of the OnionService from its key provider
+// into keyFile. Version 3 keys are converted into the format of tor.
+func fetchPrivateKey(stop <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchKeyTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+
+	onion := &torv1beta1.OnionService{}
+	if err := c.Get(ctx, types.NamespacedName{Name: onionServiceName, Namespace: onionServiceNamespace}, onion); err != nil {
+		return err
+	}
+
+	provider := keyprovider.ForOnionService(c, onion)
+	if provider == nil {
+		return fmt.Errorf("OnionService %s/%s has no private key to fetch", onion.Namespace, onion.Name)
+	}
+
+	data, err := provider.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	if onion.Spec.Version == 3 {
+		key, _, err := keys.ParseSecretKey(data)
+		if err != nil {
+			return err
+		}
+		data = key.SecretKeyFile()
+		setupLog.Info("fetched private key", "hostname", key.Hostname())
+	}
+
+	return ioutil.WriteFile(keyFile, data, 0600)
+}
@@ -4,7 +4,11 @@ import (
 	"flag"
 	"fmt"
 	torv1alpha1 "github.com/marcus-sa/tor-operator/api/v1alpha1"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
 	"github.com/marcus-sa/tor-operator/controllers"
+	"github.com/marcus-sa/tor-operator/pkg/config"
+	"github.com/marcus-sa/tor-operator/pkg/control"
+	"github.com/marcus-sa/tor-operator/pkg/metrics"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -20,20 +24,35 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 	onionServiceNamespace string
 	metricsAddr string
+	healthProbeAddr string
 	onionServiceName string
+	poolName string
+	controlPassword bool
+	fetchKey bool
+	keyFile string
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(torv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(torv1beta1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 
 	flag.StringVar(&onionServiceNamespace, "namespace", "",
 		"The namespace of the OnionService to manage.")
 	flag.StringVar(&onionServiceName, "name", "",
 		"The name of the OnionService to manage.")
+	flag.StringVar(&poolName, "pool", "",
+		"The name of the TorDaemonPool to manage instead of a single OnionService.")
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&healthProbeAddr, "health-probe-addr", ":8081", "The address the health probe endpoints bind to.")
+	flag.BoolVar(&controlPassword, "control-password", false,
+		"Protect the control port with a password generated at startup instead of cookie authentication.")
+	flag.BoolVar(&fetchKey, "fetch-key", false,
+		"Fetch the private key of the OnionService from its key provider into --key-file and exit.")
+	flag.StringVar(&keyFile, "key-file", "",
+		"The file the private key is written to with --fetch-key.")
 }
 
 func main() {
@@ -42,39 +61,96 @@ func main() {
 
 	var errs []error
 
-	if onionServiceName == "" {
-		errs = append(errs, fmt.Errorf("--name flag cannot be empty"))
+	if onionServiceName == "" && poolName == "" {
+		errs = append(errs, fmt.Errorf("--name or --pool flag cannot be empty"))
+	}
+	if onionServiceName != "" && poolName != "" {
+		errs = append(errs, fmt.Errorf("--name and --pool flags are mutually exclusive"))
 	}
 	if onionServiceNamespace == "" {
 		errs = append(errs, fmt.Errorf("--namespace flag cannot be empty"))
 	}
+	if fetchKey && (onionServiceName == "" || keyFile == "") {
+		errs = append(errs, fmt.Errorf("--fetch-key requires the --name and --key-file flags"))
+	}
 	if err := errors.NewAggregate(errs); err != nil {
 		ctrl.Log.Error(err, "unable to set up overall controller manager")
 		os.Exit(1)
 	}
 
+	if fetchKey {
+		if err := fetchPrivateKey(ctrl.SetupSignalHandler()); err != nil {
+			setupLog.Error(err, "unable to fetch private key")
+			os.Exit(1)
+		}
+		return
+	}
+
+	var password string
+	if controlPassword {
+		var err error
+		if password, err = control.NewPassword(); err != nil {
+			setupLog.Error(err, "unable to generate control port password")
+			os.Exit(1)
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		Port:                   9443,
+		HealthProbeBindAddress: healthProbeAddr,
+		LivenessEndpointName:   "/healthz",
+		ReadinessEndpointName:  "/readyz",
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.TorDaemonReconciler{
+	reconciler := &controllers.TorDaemonReconciler{
 		Client:                mgr.GetClient(),
+		Recorder:              mgr.GetEventRecorderFor("TorDaemon"),
 		Log:                   ctrl.Log.WithName("controllers").WithName("TorDaemon"),
 		Scheme:                mgr.GetScheme(),
 		OnionServiceName:      onionServiceName,
 		OnionServiceNamespace: onionServiceNamespace,
-	}).SetupWithManager(mgr); err != nil {
+		PoolName:              poolName,
+		ControlPassword:       password,
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "TorDaemon")
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
+	if err := mgr.AddHealthzCheck("tor", reconciler.Healthz); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("tor", reconciler.Readyz); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	// the metrics of a pool are labeled with the name of the pool
+	metricsName := onionServiceName
+	if poolName != "" {
+		metricsName = poolName
+	}
+
+	exporter := &metrics.TorDaemonMetricsExporter{
+		OnionServiceName:      metricsName,
+		OnionServiceNamespace: onionServiceNamespace,
+		Socket:                config.ControlSocket,
+		Password:              password,
+		MetricsPort:           config.MetricsPort,
+	}
+	if err := exporter.Register(); err != nil {
+		setupLog.Error(err, "unable to register tor daemon metrics")
+		os.Exit(1)
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// findCondition returns the condition of type conditionType or nil.
+func findCondition(status *torv1beta1.OnionServiceStatus, conditionType torv1beta1.OnionServiceConditionType) *torv1beta1.OnionServiceCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setCondition adds or updates a condition of status. The transition time is
+// only changed when the status of the condition changes.
+func setCondition(status *torv1beta1.OnionServiceStatus, conditionType torv1beta1.OnionServiceConditionType,
+	conditionStatus corev1.ConditionStatus, reason, message string) {
+	existing := findCondition(status, conditionType)
+	if existing == nil {
+		status.Conditions = append(status.Conditions, torv1beta1.OnionServiceCondition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			LastTransitionTime: metav1.Now(),
+			Reason:             reason,
+			Message:            message,
+		})
+		return
+	}
+
+	if existing.Status != conditionStatus {
+		existing.Status = conditionStatus
+		existing.LastTransitionTime = metav1.Now()
+	}
+	existing.Reason = reason
+	existing.Message = message
+}
+
+// removeCondition drops the condition of type conditionType from status.
+func removeCondition(status *torv1beta1.OnionServiceStatus, conditionType torv1beta1.OnionServiceConditionType) {
+	var conditions []torv1beta1.OnionServiceCondition
+	for _, condition := range status.Conditions {
+		if condition.Type != conditionType {
+			conditions = append(conditions, condition)
+		}
+	}
+	status.Conditions = conditions
+}
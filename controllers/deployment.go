@@ -1,11 +1,16 @@
 package controllers
 
 import (
-	torv1alpha1 "github.com/marcus-sa/tor-operator/api/v1alpha1"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/config"
+	"github.com/marcus-sa/tor-operator/pkg/keyprovider"
+	"github.com/marcus-sa/tor-operator/pkg/keys"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"path"
 	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -15,83 +20,251 @@ const (
 	privateKeyVolume = "private-key"
 	torConfigVolume  = "tor-config"
 	imageName        = "quay.io/tor-operator/daemon-manager:latest"
+	// healthProbePort is the default --health-probe-addr of the daemon manager
+	healthProbePort = 8081
+	// fetchedKeyDir is where the init container fetching a key from an
+	// external store writes it
+	fetchedKeyDir = "/keys"
 )
 
-func (r *OnionServiceReconciler) torDeployment() (*appsv1.Deployment, error) {
-	labels := map[string]string{
-		"app": "tor",
-		"api": "tor",
-		"controller": r.instance.Name,
-	}
-
-	privateKeyMountPath := "/run/tor/service/hs_ed25519_secret_key"
-	if r.instance.Spec.Version == 2 {
-		privateKeyMountPath = "/run/tor/service/private_key"
+func torProbe(path string, initialDelaySeconds int32) *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path:   path,
+				Port:   intstr.FromInt(healthProbePort),
+				Scheme: corev1.URISchemeHTTP,
+			},
+		},
+		InitialDelaySeconds: initialDelaySeconds,
+		TimeoutSeconds:      1,
+		PeriodSeconds:       10,
+		SuccessThreshold:    1,
+		FailureThreshold:    3,
 	}
+}
 
-	// allow not specifying a private key
+// privateKeyVolumes returns the volumes mounting the private keys of onion
+// into its HiddenServiceDir dir. Without a private key tor generates one.
+// Keys of external stores are fetched into memory by the returned init
+// containers.
+func privateKeyVolumes(onion *torv1beta1.OnionService, pk *privateKey, volumeName, dir string) ([]corev1.Volume, []corev1.VolumeMount, []corev1.Container) {
 	var volumes []corev1.Volume
 	var volumeMounts []corev1.VolumeMount
+	var initContainers []corev1.Container
 
-	if r.instance.Spec.PrivateKeySecret != (torv1alpha1.SecretReference{}) {
-		volumes = []corev1.Volume{
-			{
-				Name: privateKeyVolume,
+	if pk != nil {
+		privateKeyFileName := keys.SecretKeyFileName
+		if onion.Spec.Version == 2 {
+			privateKeyFileName = "private_key"
+		}
+
+		subPath := privateKeyFileName
+		if pk.external {
+			volumes = append(volumes, corev1.Volume{
+				Name: volumeName,
 				VolumeSource: corev1.VolumeSource{
-					Secret: &corev1.SecretVolumeSource{
-						SecretName: r.instance.Spec.PrivateKeySecret.Name,
+					EmptyDir: &corev1.EmptyDirVolumeSource{
+						Medium: corev1.StorageMediumMemory,
 					},
 				},
-			},
+			})
+			initContainers = append(initContainers, fetchKeyContainer(onion, volumeName, privateKeyFileName))
+		} else {
+			volumes = append(volumes, secretVolume(volumeName, pk.secret.Name))
+			subPath = pk.secret.Key
 		}
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      volumeName,
+			MountPath: path.Join(dir, privateKeyFileName),
+			SubPath:   subPath,
+		})
+	}
+
+	if onion.Spec.Version == 2 && onion.Spec.Migration != nil {
+		secret := migrationKeySecret(onion)
+		volumes = append(volumes, secretVolume(volumeName+"-v3", secret.Name))
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      volumeName + "-v3",
+			MountPath: path.Join(config.MigrationServiceDir(dir), keys.SecretKeyFileName),
+			SubPath:   secret.Key,
+		})
+	}
 
-		volumeMounts = []corev1.VolumeMount{
+	if rotation := onion.Status.KeyRotation; rotation != nil && rotation.PreviousKeySecret != nil {
+		volumes = append(volumes, secretVolume(volumeName+"-retiring", rotation.PreviousKeySecret.Name))
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      volumeName + "-retiring",
+			MountPath: path.Join(config.RetiringServiceDir(dir), keys.SecretKeyFileName),
+			SubPath:   rotation.PreviousKeySecret.Key,
+		})
+	}
+
+	return volumes, volumeMounts, initContainers
+}
+
+// fetchKeyContainer returns the init container writing the key of onion from
+// its KeyProvider into fileName of the volume volumeName.
+func fetchKeyContainer(onion *torv1beta1.OnionService, volumeName, fileName string) corev1.Container {
+	container := corev1.Container{
+		Name:  "fetch-" + volumeName,
+		Image: imageName,
+		Args: []string{
+			"--fetch-key",
+			"--name",
+			onion.Name,
+			"--namespace",
+			onion.Namespace,
+			"--key-file",
+			path.Join(fetchedKeyDir, fileName),
+		},
+		ImagePullPolicy: "IfNotPresent",
+		VolumeMounts: []corev1.VolumeMount{
 			{
-				Name:      privateKeyVolume,
-				MountPath: privateKeyMountPath,
-				SubPath:   r.instance.Spec.PrivateKeySecret.Key,
+				Name:      volumeName,
+				MountPath: fetchedKeyDir,
 			},
-		}
+		},
 	}
 
-	deployment := &appsv1.Deployment{
-		ObjectMeta: *r.NewObjectMeta(),
-		Spec: appsv1.DeploymentSpec{
-			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+	if vault := onion.Spec.KeyProvider.Vault; vault != nil && vault.TokenSecret != nil {
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name: keyprovider.TokenEnv,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: vault.TokenSecret.Name},
+					Key:                  vault.TokenSecret.Key,
 				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  "tor",
-							Image: imageName,
-							Args: []string{
-								"--name",
-								r.instance.Name,
-								"--namespace",
-								r.instance.Namespace,
-							},
-							ImagePullPolicy: "IfNotPresent",
-
-							VolumeMounts: volumeMounts,
-						},
+			},
+		})
+	}
+	return container
+}
+
+func secretVolume(name, secretName string) corev1.Volume {
+	return corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	}
+}
+
+// torDeploymentSpec returns the spec of a Deployment running the tor daemon
+// manager with args as serviceAccountName.
+func torDeploymentSpec(labels map[string]string, serviceAccountName string, strategy appsv1.DeploymentStrategyType,
+	args []string, volumes []corev1.Volume, volumeMounts []corev1.VolumeMount, initContainers []corev1.Container) appsv1.DeploymentSpec {
+	return appsv1.DeploymentSpec{
+		Selector: &metav1.LabelSelector{
+			MatchLabels: labels,
+		},
+		Strategy: appsv1.DeploymentStrategy{
+			Type: strategy,
+		},
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: labels,
+			},
+			Spec: corev1.PodSpec{
+				// external key stores authenticate the daemon by its
+				// service account
+				ServiceAccountName: serviceAccountName,
+				InitContainers:     initContainers,
+				Containers: []corev1.Container{
+					{
+						Name:            "tor",
+						Image:           imageName,
+						Args:            args,
+						ImagePullPolicy: "IfNotPresent",
+						LivenessProbe:   torProbe("/healthz", 10),
+						// tor needs a while to bootstrap and publish
+						// the descriptor
+						ReadinessProbe: torProbe("/readyz", 30),
+
+						VolumeMounts: volumeMounts,
 					},
-					Volumes: volumes,
 				},
+				Volumes: volumes,
 			},
 		},
 	}
+}
+
+// rolloutStrategy returns the strategy of the Deployment of onion. Two
+// daemons with the same key race on the descriptor, so the old one is stopped
+// first unless configured otherwise.
+func rolloutStrategy(onion *torv1beta1.OnionService) appsv1.DeploymentStrategyType {
+	if rollout := onion.Spec.Rollout; rollout != nil && rollout.Strategy != "" {
+		return rollout.Strategy
+	}
+	return appsv1.RecreateDeploymentStrategyType
+}
+
+// torPodLabels returns the labels of the daemon of the OnionService name.
+func torPodLabels(name string) map[string]string {
+	return map[string]string{
+		"app": "tor",
+		"api": "tor",
+		"controller": name,
+	}
+}
+
+func (r *OnionServiceReconciler) torDeployment() (*appsv1.Deployment, error) {
+	labels := torPodLabels(r.instance.Name)
+
+	volumes, volumeMounts, initContainers := privateKeyVolumes(r.instance, r.privateKey, privateKeyVolume, config.ServiceDir)
+
+	args := []string{
+		"--name",
+		r.instance.Name,
+		"--namespace",
+		r.instance.Namespace,
+	}
+
+	// a changed key only takes effect in a new pod
+	hash, err := privateKeyHash(r.ctx, r, r.instance.Namespace, volumes, volumeMounts)
+	if err != nil {
+		return nil, err
+	}
 
-	err := controllerutil.SetControllerReference(r.instance, deployment, r.Scheme)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: *r.NewObjectMeta(),
+		Spec:       torDeploymentSpec(labels, r.instance.Name, rolloutStrategy(r.instance), args, volumes, volumeMounts, initContainers),
+	}
+	deployment.Spec.Template.Annotations = podTemplateAnnotations(hash, r.instance.Spec.Mode)
+
+	err = controllerutil.SetControllerReference(r.instance, deployment, r.Scheme)
 	return deployment, err
 }
 
+// modeAnnotation marks the pod template of daemons in single onion mode. Tor
+// refuses to switch the mode of a running daemon, so changing it rolls out
+// the daemon.
+const modeAnnotation = "tor.k8s.io/mode"
+
+// podTemplateAnnotations returns the annotations of the pod template of a
+// daemon with the keys hashed into keyHash and mode.
+func podTemplateAnnotations(keyHash string, mode torv1beta1.OnionServiceMode) map[string]string {
+	annotations := map[string]string{
+		privateKeyHashAnnotation: keyHash,
+	}
+	if mode == torv1beta1.SingleOnionMode {
+		annotations[modeAnnotation] = string(mode)
+	}
+	return annotations
+}
+
 func (r *OnionServiceReconciler) ReconcileDeployment(req ctrl.Request) error {
-	deployment, _ := r.torDeployment()
+	if r.instance.Spec.Pool != "" {
+		return r.deletePooledDeployment(req)
+	}
+
+	deployment, err := r.torDeployment()
+	if err != nil {
+		return err
+	}
 	found := &appsv1.Deployment{}
 
 	if err := r.Get(r.ctx, req.NamespacedName, found); err != nil {
@@ -120,3 +293,22 @@ func (r *OnionServiceReconciler) ReconcileDeployment(req ctrl.Request) error {
 
 	return nil
 }
+
+// deletePooledDeployment removes the daemon of an onion that moved into a
+// TorDaemonPool, two daemons with the same key would race on the descriptor.
+func (r *OnionServiceReconciler) deletePooledDeployment(req ctrl.Request) error {
+	found := &appsv1.Deployment{}
+	if err := r.Get(r.ctx, req.NamespacedName, found); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !metav1.IsControlledBy(found, r.instance) {
+		return nil
+	}
+
+	r.Log.Info("Deleting Deployment %s/%s\n", found.Namespace, found.Name)
+	return r.Delete(r.ctx, found)
+}
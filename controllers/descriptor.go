@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"github.com/marcus-sa/tor-operator/pkg/control"
+	"sync"
+	"time"
+)
+
+// descriptorTracker follows the HS_DESC events of the current upload round of
+// the hidden service descriptor. A round starts with the first UPLOAD after
+// all uploads of the previous round have either succeeded or failed.
+type descriptorTracker struct {
+	mu        sync.Mutex
+	uploads   map[string]string
+	published time.Time
+	watching  bool
+}
+
+// handle records event and reports whether the upload round is complete.
+func (t *descriptorTracker) handle(event *control.HSDescEvent) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch event.Action {
+	case control.HSDescUpload:
+		if t.uploads == nil || !t.pending() {
+			t.uploads = map[string]string{}
+		}
+	case control.HSDescUploaded, control.HSDescFailed:
+		// FAILED is also reported for fetches, only track HSDirs we upload to
+		if _, ok := t.uploads[event.HSDir]; !ok {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if event.Action == control.HSDescUploaded {
+		t.published = time.Now()
+	}
+	t.uploads[event.HSDir] = event.Action
+
+	return !t.pending()
+}
+
+func (t *descriptorTracker) pending() bool {
+	for _, action := range t.uploads {
+		if action == control.HSDescUpload {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns when the descriptor was last published and how many HSDirs
+// accepted or refused it in the last complete round.
+func (t *descriptorTracker) status() (published time.Time, uploaded, failed int, complete bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, action := range t.uploads {
+		switch action {
+		case control.HSDescUploaded:
+			uploaded++
+		case control.HSDescFailed:
+			failed++
+		}
+	}
+
+	return t.published, uploaded, failed, len(t.uploads) > 0 && !t.pending()
+}
+
+func (t *descriptorTracker) setWatching(watching bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.watching = watching
+}
+
+func (t *descriptorTracker) isWatching() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.watching
+}
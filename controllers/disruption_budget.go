@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// wantsDisruptionBudget tells whether the daemon of the instance is protected
+// from evictions. Pooled onions have no daemon of their own.
+func (r *OnionServiceReconciler) wantsDisruptionBudget() bool {
+	rollout := r.instance.Spec.Rollout
+	return r.instance.Spec.Pool == "" && rollout != nil && rollout.PodDisruptionBudget
+}
+
+func (r *OnionServiceReconciler) torPodDisruptionBudget() (*policyv1beta1.PodDisruptionBudget, error) {
+	// the only daemon must stay available, an evicted daemon is replaced
+	// before it terminated and would race with its replacement
+	minAvailable := intstr.FromInt(1)
+
+	pdb := &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: *r.NewObjectMeta(),
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: torPodLabels(r.instance.Name),
+			},
+		},
+	}
+
+	err := controllerutil.SetControllerReference(r.instance, pdb, r.Scheme)
+	return pdb, err
+}
+
+// ReconcilePodDisruptionBudget protects the daemon of the instance from
+// evictions if spec.rollout.podDisruptionBudget is set.
+func (r *OnionServiceReconciler) ReconcilePodDisruptionBudget(req ctrl.Request) error {
+	found := &policyv1beta1.PodDisruptionBudget{}
+	err := r.Get(r.ctx, req.NamespacedName, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if !r.wantsDisruptionBudget() {
+		if !exists || !metav1.IsControlledBy(found, r.instance) {
+			return nil
+		}
+		r.Log.Info("Deleting PodDisruptionBudget %s/%s\n", found.Namespace, found.Name)
+		return r.Delete(r.ctx, found)
+	}
+
+	pdb, err := r.torPodDisruptionBudget()
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		r.Log.Info("Creating PodDisruptionBudget %s/%s\n", pdb.Namespace, pdb.Name)
+		return r.Create(r.ctx, pdb)
+	}
+
+	if !reflect.DeepEqual(pdb.Spec, found.Spec) {
+		found.Spec = pdb.Spec
+		r.Log.Info("Updating PodDisruptionBudget %s/%s\n", pdb.Namespace, pdb.Name)
+		return r.Update(r.ctx, found)
+	}
+
+	return nil
+}
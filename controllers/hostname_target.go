@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// hostnameTargetMeta returns the metadata of a hostname target created by the
+// operator, which is owned by the OnionService like all its other resources.
+func (r *OnionServiceReconciler) hostnameTargetMeta(name string) metav1.ObjectMeta {
+	meta := *r.NewObjectMeta()
+	meta.Name = name
+	return meta
+}
+
+// ReconcileHostnameTarget writes the hostname of the onion into the
+// ConfigMap or Secret referenced by spec.hostnameTarget. Nothing is written
+// until tor published a hostname.
+func (r *OnionServiceReconciler) ReconcileHostnameTarget() error {
+	target := r.instance.Spec.HostnameTarget
+	if target == nil {
+		return nil
+	}
+
+	hostname := r.instance.Status.Hostname
+	if hostname == "" {
+		return nil
+	}
+
+	key := target.Key
+	if key == "" {
+		key = torv1beta1.DefaultHostnameTargetKey
+	}
+
+	name := types.NamespacedName{Name: target.Name, Namespace: r.instance.Namespace}
+
+	switch target.Kind {
+	case torv1beta1.HostnameTargetConfigMap:
+		configMap := &corev1.ConfigMap{}
+		err := r.Get(r.ctx, name, configMap)
+		if errors.IsNotFound(err) {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: r.hostnameTargetMeta(target.Name),
+				Data:       map[string]string{key: hostname},
+			}
+			return r.Create(r.ctx, configMap)
+		}
+		if err != nil {
+			return err
+		}
+
+		if configMap.Data[key] == hostname {
+			return nil
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[key] = hostname
+		r.Log.Info("Updating ConfigMap %s/%s\n", configMap.Namespace, configMap.Name)
+		return r.Update(r.ctx, configMap)
+
+	case torv1beta1.HostnameTargetSecret:
+		secret := &corev1.Secret{}
+		err := r.Get(r.ctx, name, secret)
+		if errors.IsNotFound(err) {
+			secret = &corev1.Secret{
+				ObjectMeta: r.hostnameTargetMeta(target.Name),
+				Data:       map[string][]byte{key: []byte(hostname)},
+			}
+			return r.Create(r.ctx, secret)
+		}
+		if err != nil {
+			return err
+		}
+
+		if string(secret.Data[key]) == hostname {
+			return nil
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[key] = []byte(hostname)
+		r.Log.Info("Updating Secret %s/%s\n", secret.Namespace, secret.Name)
+		return r.Update(r.ctx, secret)
+	}
+
+	return fmt.Errorf("unknown hostnameTarget kind %q", target.Kind)
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
+)
+
+const (
+	nginxSnippetAnnotation   = "nginx.ingress.kubernetes.io/configuration-snippet"
+	traefikHeadersAnnotation = "ingress.kubernetes.io/custom-response-headers"
+	onionLocationHeader      = "Onion-Location"
+
+	// onionLocationFinalizer holds deleted OnionServices until the
+	// Onion-Location header is removed from their Ingress
+	onionLocationFinalizer = "tor.k8s.io/onion-location"
+)
+
+// onionLocationAnnotations returns the annotations of ingress with the
+// Onion-Location header set to url, or removed if url is empty. Any other
+// content of the annotations is kept.
+func onionLocationAnnotations(ingressClass string, annotations map[string]string, url string) map[string]string {
+	result := map[string]string{}
+	for k, v := range annotations {
+		result[k] = v
+	}
+
+	var key, separator, header string
+	switch ingressClass {
+	case torv1beta1.IngressClassNginx:
+		key, separator = nginxSnippetAnnotation, "\n"
+		header = fmt.Sprintf(`more_set_headers "%s: %s$request_uri";`, onionLocationHeader, url)
+	case torv1beta1.IngressClassTraefik:
+		key, separator = traefikHeadersAnnotation, "||"
+		header = fmt.Sprintf("%s:%s", onionLocationHeader, url)
+	default:
+		return result
+	}
+
+	var parts []string
+	if result[key] != "" {
+		for _, part := range strings.Split(result[key], separator) {
+			if !strings.Contains(part, onionLocationHeader) {
+				parts = append(parts, part)
+			}
+		}
+	}
+	if url != "" {
+		parts = append(parts, header)
+	}
+
+	if len(parts) == 0 {
+		delete(result, key)
+	} else {
+		result[key] = strings.Join(parts, separator)
+	}
+	return result
+}
+
+// ReconcileIngress keeps the Onion-Location header of the referenced Ingress
+// pointing at the current hostname of the onion. The header is removed from
+// the Ingress it was added to once spec.onionLocation is removed or points
+// elsewhere, status.onionLocation records that Ingress.
+func (r *OnionServiceReconciler) ReconcileIngress() error {
+	spec := r.instance.Spec.OnionLocation
+	status := &r.instance.Status
+
+	if applied := status.OnionLocation; applied != nil && (spec == nil || *applied != *spec) {
+		if err := r.setOnionLocation(applied, ""); err != nil {
+			return err
+		}
+		status.OnionLocation = nil
+	}
+
+	if spec == nil {
+		return nil
+	}
+
+	var url string
+	if status.Hostname != "" {
+		url = "http://" + status.Hostname
+	}
+
+	if err := r.setOnionLocation(spec, url); err != nil {
+		return err
+	}
+	status.OnionLocation = spec.DeepCopy()
+	return nil
+}
+
+// setOnionLocation sets the Onion-Location header of the Ingress of spec to
+// url, or removes it if url is empty. Removing the header from an Ingress
+// that no longer exists succeeds.
+func (r *OnionServiceReconciler) setOnionLocation(spec *torv1beta1.OnionLocationSpec, url string) error {
+	ingress := &networkingv1beta1.Ingress{}
+	name := types.NamespacedName{Name: spec.IngressName, Namespace: r.instance.Namespace}
+	if err := r.Get(r.ctx, name, ingress); err != nil {
+		if errors.IsNotFound(err) && url == "" {
+			return nil
+		}
+		return err
+	}
+
+	annotations := onionLocationAnnotations(spec.IngressClass, ingress.Annotations, url)
+	if annotations[nginxSnippetAnnotation] == ingress.Annotations[nginxSnippetAnnotation] &&
+		annotations[traefikHeadersAnnotation] == ingress.Annotations[traefikHeadersAnnotation] {
+		return nil
+	}
+
+	ingress.Annotations = annotations
+	r.Log.Info("Updating Onion-Location of Ingress %s/%s\n", ingress.Namespace, ingress.Name)
+	return r.Update(r.ctx, ingress)
+}
+
+// ReconcileOnionLocationFinalizer keeps a finalizer on the instance for as
+// long as an Ingress may carry its Onion-Location header. It has to run before
+// the status of the instance is changed, as the update replaces it.
+func (r *OnionServiceReconciler) ReconcileOnionLocationFinalizer() error {
+	want := r.instance.Spec.OnionLocation != nil || r.instance.Status.OnionLocation != nil
+	if want == controllerutil.ContainsFinalizer(r.instance, onionLocationFinalizer) {
+		return nil
+	}
+
+	if want {
+		controllerutil.AddFinalizer(r.instance, onionLocationFinalizer)
+	} else {
+		controllerutil.RemoveFinalizer(r.instance, onionLocationFinalizer)
+	}
+	return r.Update(r.ctx, r.instance)
+}
+
+// FinalizeOnionLocation removes the Onion-Location header of the deleted
+// instance from its Ingress and then releases the instance.
+func (r *OnionServiceReconciler) FinalizeOnionLocation() error {
+	if !controllerutil.ContainsFinalizer(r.instance, onionLocationFinalizer) {
+		return nil
+	}
+
+	for _, spec := range []*torv1beta1.OnionLocationSpec{r.instance.Status.OnionLocation, r.instance.Spec.OnionLocation} {
+		if spec == nil {
+			continue
+		}
+		if err := r.setOnionLocation(spec, ""); err != nil {
+			return err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(r.instance, onionLocationFinalizer)
+	return r.Update(r.ctx, r.instance)
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/keys"
+	"github.com/marcus-sa/tor-operator/pkg/metrics"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+const (
+	// KeyRotated is used as part of the Event 'reason' when the key of an
+	// OnionService is rotated
+	KeyRotated = "KeyRotated"
+	// MessageKeyRotated is the message used for Events fired when the key of
+	// an OnionService is rotated
+	MessageKeyRotated = "Rotated key to %s"
+	// MessageKeyNotServed is the message used for Events warning that the
+	// previous key cannot be served during the overlap
+	MessageKeyNotServed = "Previous key was generated by tor and is not stored in a Secret, %s stops working immediately"
+	// KeyRetired is used as part of the Event 'reason' when the previous key
+	// of a rotation stops being served
+	KeyRetired = "KeyRetired"
+	// MessageKeyRetired is the message used for Events fired when the
+	// previous key of a rotation stops being served
+	MessageKeyRetired = "Retired previous key of %s"
+
+	// rotatedKeyLabel marks the Secrets generated by a rotation with the name
+	// of their OnionService, only those are deleted once retired
+	rotatedKeyLabel = "tor.k8s.io/rotated-key"
+
+	defaultKeyRotationOverlap = 24 * time.Hour
+
+	// retiredKeyRequeue is how often the rollout is checked while retired
+	// keys wait for it, the Deployment of a pool does not trigger a reconcile
+	retiredKeyRequeue = 10 * time.Second
+)
+
+func keyRotationOverlap(spec *torv1beta1.KeyRotationSpec) time.Duration {
+	if spec.Overlap.Duration > 0 {
+		return spec.Overlap.Duration
+	}
+	return defaultKeyRotationOverlap
+}
+
+// rotatedKeySecret references the key generated for the rotation with token.
+func rotatedKeySecret(onion *torv1beta1.OnionService, token string) *torv1beta1.SecretReference {
+	hash := sha256.Sum256([]byte(token))
+	return &torv1beta1.SecretReference{
+		Name: fmt.Sprintf("%s-key-%x", onion.Name, hash[:4]),
+		Key:  keys.SecretKeyFileName,
+	}
+}
+
+// ReconcileKeyRotation generates a new key whenever the rotation token of the
+// instance changes and retires the previous key once the overlap is over. The
+// last generated key keeps being served once keyRotation is removed, as
+// reverting to the key it replaced would change the address again. The
+// results are recorded in the status of the instance, which is written by
+// UpdateServiceStatus.
+func (r *OnionServiceReconciler) ReconcileKeyRotation() error {
+	spec := r.instance.Spec.KeyRotation
+	status := &r.instance.Status
+
+	if r.instance.Spec.Version != 3 {
+		status.KeyRotation = nil
+		return nil
+	}
+
+	rotation := status.KeyRotation
+	if rotation != nil && rotation.RetireTime != nil && !rotation.RetireTime.After(time.Now()) {
+		r.retireKey(rotation)
+	}
+
+	if spec == nil || rotation != nil && rotation.Token == spec.Token {
+		return nil
+	}
+
+	// the key in use becomes the previous key
+	var previous *torv1beta1.SecretReference
+	if rotation != nil {
+		r.retireKey(rotation)
+		previous = rotation.KeySecret.DeepCopy()
+	} else {
+		pk, err := readPrivateKey(r.ctx, r, r.instance)
+		if err != nil {
+			return err
+		}
+		if pk != nil && pk.invalid == nil {
+			previous = pk.secret.DeepCopy()
+		}
+	}
+
+	ref := rotatedKeySecret(r.instance, spec.Token)
+	hostname, err := r.generatedKey(ref, map[string]string{rotatedKeyLabel: r.instance.Name},
+		metrics.KeySourceRotation)
+	if err != nil {
+		return err
+	}
+
+	next := &torv1beta1.KeyRotationStatus{
+		Token:     spec.Token,
+		KeySecret: *ref,
+		Hostname:  hostname,
+	}
+	if previous != nil {
+		retireTime := metav1.NewTime(time.Now().Add(keyRotationOverlap(spec)))
+		next.PreviousKeySecret = previous
+		next.PreviousHostname = status.Hostname
+		next.RetireTime = &retireTime
+	} else if status.Hostname != "" {
+		r.Recorder.Event(r.instance, corev1.EventTypeWarning, KeyRotated, fmt.Sprintf(MessageKeyNotServed, status.Hostname))
+	}
+
+	status.KeyRotation = next
+	r.Recorder.Event(r.instance, corev1.EventTypeNormal, KeyRotated, fmt.Sprintf(MessageKeyRotated, hostname))
+	return nil
+}
+
+// retireKey stops serving the previous key of rotation. Its Secret is deleted
+// by deleteRetiredKeys once the daemon no longer mounts it.
+func (r *OnionServiceReconciler) retireKey(rotation *torv1beta1.KeyRotationStatus) {
+	if rotation.PreviousKeySecret == nil {
+		return
+	}
+
+	r.Recorder.Event(r.instance, corev1.EventTypeNormal, KeyRetired, fmt.Sprintf(MessageKeyRetired, rotation.PreviousHostname))
+	rotation.PreviousKeySecret = nil
+	rotation.PreviousHostname = ""
+	rotation.RetireTime = nil
+}
+
+// deleteRetiredKeys deletes the Secrets generated by rotations of the
+// instance which are no longer referenced by its status. Secrets are kept
+// until the Deployment serving the instance has rolled out without them, as
+// running daemons still mount them. It returns true while Secrets are waiting
+// for the rollout.
+func (r *OnionServiceReconciler) deleteRetiredKeys() (bool, error) {
+	secrets := &corev1.SecretList{}
+	if err := r.List(r.ctx, secrets, client.InNamespace(r.instance.Namespace),
+		client.MatchingLabels{rotatedKeyLabel: r.instance.Name}); err != nil {
+		return false, err
+	}
+
+	inUse := map[string]bool{}
+	if rotation := r.instance.Status.KeyRotation; rotation != nil {
+		inUse[rotation.KeySecret.Name] = true
+		if rotation.PreviousKeySecret != nil {
+			inUse[rotation.PreviousKeySecret.Name] = true
+		}
+	}
+	if ref := r.instance.Spec.PrivateKeySecret; ref != nil {
+		inUse[ref.Name] = true
+	}
+
+	var retired []corev1.Secret
+	for _, secret := range secrets.Items {
+		if !inUse[secret.Name] {
+			retired = append(retired, secret)
+		}
+	}
+	if len(retired) == 0 {
+		return false, nil
+	}
+
+	mounted, err := r.mountedSecrets()
+	if err != nil {
+		return false, err
+	}
+
+	pending := false
+	for i := range retired {
+		secret := &retired[i]
+		if mounted == nil || mounted[secret.Name] {
+			pending = true
+			continue
+		}
+		r.Log.Info("Deleting Secret %s/%s\n", secret.Namespace, secret.Name)
+		if err := r.Delete(r.ctx, secret); err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+	}
+	return pending, nil
+}
+
+// mountedSecrets returns the names of the Secrets mounted by the Deployment
+// running the daemon of the instance, which is the one of its pool for
+// pooled onions. It returns nil while the Deployment is rolling out, as the
+// pods of the previous template may still mount any of them.
+func (r *OnionServiceReconciler) mountedSecrets() (map[string]bool, error) {
+	name := r.instance.Name
+	if r.instance.Spec.Pool != "" {
+		name = r.instance.Spec.Pool
+	}
+
+	mounted := map[string]bool{}
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(r.ctx, types.NamespacedName{Name: name, Namespace: r.instance.Namespace}, deployment); err != nil {
+		if errors.IsNotFound(err) {
+			return mounted, nil
+		}
+		return nil, err
+	}
+
+	status := deployment.Status
+	if status.ObservedGeneration < deployment.Generation || status.UpdatedReplicas < status.Replicas {
+		return nil, nil
+	}
+
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if volume.Secret != nil {
+			mounted[volume.Secret.SecretName] = true
+		}
+	}
+	return mounted, nil
+}
+
+// retireAfter returns how long until the previous key of the instance is
+// retired, or 0 if no key is retiring.
+func (r *OnionServiceReconciler) retireAfter() time.Duration {
+	rotation := r.instance.Status.KeyRotation
+	if rotation == nil || rotation.RetireTime == nil {
+		return 0
+	}
+	if d := time.Until(rotation.RetireTime.Time); d > 0 {
+		return d
+	}
+	// retired by the next reconcile
+	return time.Second
+}
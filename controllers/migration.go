@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/keys"
+	"github.com/marcus-sa/tor-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// Deprecated is used as part of the Event 'reason' when an
+	// OnionService uses a version 2 onion
+	Deprecated = "Deprecated"
+	// MessageDeprecated is the message used for Events and conditions
+	// warning about version 2 onions
+	MessageDeprecated = "Version 2 onion services no longer work on the Tor network and are not served, set spec.migration to migrate to version 3"
+	// MessageMigrating is the message used for the Deprecated condition of
+	// version 2 onions being migrated
+	MessageMigrating = "Version 2 onion service is being migrated to %s, set version to 3 to cut over"
+
+	// generatedHostnameKey holds the address of a generated key in its
+	// Secret
+	generatedHostnameKey = "hostname"
+)
+
+// migrationKeySecret references the generated version 3 key of onion.
+func migrationKeySecret(onion *torv1beta1.OnionService) *torv1beta1.SecretReference {
+	name := onion.Spec.Migration.KeySecretName
+	if name == "" {
+		name = onion.Name + "-v3-key"
+	}
+
+	return &torv1beta1.SecretReference{
+		Name: name,
+		Key:  keys.SecretKeyFileName,
+	}
+}
+
+// ReconcileMigration flags version 2 onions as deprecated and provisions the
+// version 3 key of a migration. The results are recorded in the status of the
+// instance, which is written by UpdateServiceStatus.
+func (r *OnionServiceReconciler) ReconcileMigration() error {
+	status := &r.instance.Status
+
+	if r.instance.Spec.Version != 2 {
+		removeCondition(status, torv1beta1.OnionServiceDeprecated)
+		status.MigrationHostname = ""
+		return nil
+	}
+
+	if r.instance.Spec.Migration == nil {
+		// warn once instead of on every reconcile
+		if condition := findCondition(status, torv1beta1.OnionServiceDeprecated); condition == nil || condition.Reason != "VersionTwo" {
+			r.Recorder.Event(r.instance, corev1.EventTypeWarning, Deprecated, MessageDeprecated)
+		}
+		setCondition(status, torv1beta1.OnionServiceDeprecated, corev1.ConditionTrue, "VersionTwo", MessageDeprecated)
+		status.MigrationHostname = ""
+		return nil
+	}
+
+	hostname, err := r.migrationKey()
+	if err != nil {
+		return err
+	}
+
+	status.MigrationHostname = hostname
+	setCondition(status, torv1beta1.OnionServiceDeprecated, corev1.ConditionTrue, "Migrating",
+		fmt.Sprintf(MessageMigrating, hostname))
+	return nil
+}
+
+// migrationKey returns the address of the version 3 key of the migration and
+// generates the key if it does not exist yet.
+func (r *OnionServiceReconciler) migrationKey() (string, error) {
+	return r.generatedKey(migrationKeySecret(r.instance), nil, metrics.KeySourceMigration)
+}
+
+// generatedKey returns the address of the key referenced by ref and generates
+// a version 3 key into a new Secret with labels if it does not exist yet. New
+// keys are counted as generated for source.
+func (r *OnionServiceReconciler) generatedKey(ref *torv1beta1.SecretReference, labels map[string]string, source string) (string, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(r.ctx, types.NamespacedName{Name: ref.Name, Namespace: r.instance.Namespace}, secret)
+	if err == nil {
+		return string(secret.Data[generatedHostnameKey]), nil
+	}
+	if !errors.IsNotFound(err) {
+		return "", err
+	}
+
+	key, err := keys.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	// not owned by the OnionService, losing the key means losing the address
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ref.Name,
+			Namespace: r.instance.Namespace,
+			Labels:    labels,
+		},
+		Data: map[string][]byte{
+			ref.Key:              key.SecretKeyFile(),
+			generatedHostnameKey: []byte(key.Hostname()),
+		},
+	}
+
+	r.Log.Info("Creating Secret %s/%s\n", secret.Namespace, secret.Name)
+	if err := r.Create(r.ctx, secret); err != nil {
+		return "", err
+	}
+	metrics.KeysGenerated.WithLabelValues("3", source).Inc()
+
+	return key.Hostname(), nil
+}
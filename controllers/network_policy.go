@@ -0,0 +1,341 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// torNetworkCIDR is the egress of the tor daemon to relays, which
+	// listen on arbitrary ports
+	torNetworkCIDR = "0.0.0.0/0"
+)
+
+// defaultPrivateCIDRs are excluded from the Tor network unless configured.
+var defaultPrivateCIDRs = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+
+// torNetworkPolicyName is the policy restricting the egress of the daemon.
+func torNetworkPolicyName(onion *torv1beta1.OnionService) string {
+	return onion.Name + "-tor"
+}
+
+// backendNetworkPolicyName is the policy restricting the ingress of the
+// backends.
+func backendNetworkPolicyName(onion *torv1beta1.OnionService) string {
+	return onion.Name + "-backend"
+}
+
+// backendPolicyPorts returns the target ports of the backends tor connects
+// to through the generated Service.
+func backendPolicyPorts(onion *torv1beta1.OnionService) []networkingv1.NetworkPolicyPort {
+	protocol := corev1.ProtocolTCP
+	var ports []networkingv1.NetworkPolicyPort
+	for _, p := range onion.Spec.Backend.Ports {
+		port := p.BackendTargetPort()
+		ports = append(ports, networkingv1.NetworkPolicyPort{
+			Protocol: &protocol,
+			Port:     &port,
+		})
+	}
+	return ports
+}
+
+// daemonLabels returns the labels of the pods running the daemon of the
+// instance, which is the one of its pool for pooled onions.
+func (r *OnionServiceReconciler) daemonLabels() map[string]string {
+	if r.instance.Spec.Pool != "" {
+		return poolPodLabels(r.instance.Spec.Pool)
+	}
+	return torPodLabels(r.instance.Name)
+}
+
+// apiServerPeers returns the egress peers of the API server, which are the
+// ranges of cidrs if set. Otherwise connections to the kubernetes Service are
+// matched after they are forwarded to one of its endpoints.
+func apiServerPeers(ctx context.Context, c client.Reader, cidrs []string) ([]networkingv1.NetworkPolicyPeer, []networkingv1.NetworkPolicyPort, error) {
+	var peers []networkingv1.NetworkPolicyPeer
+	var ports []networkingv1.NetworkPolicyPort
+
+	if len(cidrs) > 0 {
+		for _, cidr := range cidrs {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+			})
+		}
+		return peers, nil, nil
+	}
+
+	endpoints := &corev1.Endpoints{}
+	if err := c.Get(ctx, types.NamespacedName{Name: "kubernetes", Namespace: metav1.NamespaceDefault}, endpoints); err != nil {
+		return nil, nil, err
+	}
+
+	seen := map[int32]bool{}
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: address.IP + "/32"},
+			})
+		}
+		for _, p := range subset.Ports {
+			if seen[p.Port] {
+				continue
+			}
+			seen[p.Port] = true
+			protocol, port := p.Protocol, intstr.FromInt(int(p.Port))
+			ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port})
+		}
+	}
+	if len(peers) == 0 {
+		return nil, nil, fmt.Errorf("the kubernetes Service has no endpoints, set networkPolicy.apiServerCIDRs")
+	}
+	return peers, ports, nil
+}
+
+// torEgressRules allows a daemon to reach relays outside of the private
+// ranges except, the backends of onions on their target ports and the API
+// server.
+func torEgressRules(ctx context.Context, c client.Reader, except, apiServerCIDRs []string,
+	onions []torv1beta1.OnionService) ([]networkingv1.NetworkPolicyEgressRule, error) {
+	if len(except) == 0 {
+		except = defaultPrivateCIDRs
+	}
+
+	apiServer, apiServerPorts, err := apiServerPeers(ctx, c, apiServerCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []networkingv1.NetworkPolicyEgressRule{
+		{
+			To: []networkingv1.NetworkPolicyPeer{
+				{IPBlock: &networkingv1.IPBlock{CIDR: torNetworkCIDR, Except: except}},
+			},
+		},
+	}
+	for i := range onions {
+		// an empty selector would admit every pod of the namespace
+		if len(onions[i].Spec.Backend.Selector) == 0 {
+			continue
+		}
+		rules = append(rules, networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{
+				{PodSelector: &metav1.LabelSelector{MatchLabels: onions[i].Spec.Backend.Selector}},
+			},
+			Ports: backendPolicyPorts(&onions[i]),
+		})
+	}
+	rules = append(rules, networkingv1.NetworkPolicyEgressRule{
+		To:    apiServer,
+		Ports: apiServerPorts,
+	})
+	return rules, nil
+}
+
+// torNetworkPolicy restricts the egress of the daemon of the instance.
+func (r *OnionServiceReconciler) torNetworkPolicy() (*networkingv1.NetworkPolicy, error) {
+	spec := r.instance.Spec.NetworkPolicy
+
+	egress, err := torEgressRules(r.ctx, r, spec.PrivateCIDRs, spec.APIServerCIDRs,
+		[]torv1beta1.OnionService{*r.instance})
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: *r.NewObjectMeta(),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: torPodLabels(r.instance.Name)},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      egress,
+		},
+	}
+	policy.Name = torNetworkPolicyName(r.instance)
+
+	err = controllerutil.SetControllerReference(r.instance, policy, r.Scheme)
+	return policy, err
+}
+
+// backendNetworkPolicy only admits the daemon on the target ports of the
+// backends.
+func (r *OnionServiceReconciler) backendNetworkPolicy() (*networkingv1.NetworkPolicy, error) {
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: *r.NewObjectMeta(),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: r.instance.Spec.Backend.Selector},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{PodSelector: &metav1.LabelSelector{MatchLabels: r.daemonLabels()}},
+					},
+					Ports: backendPolicyPorts(r.instance),
+				},
+			},
+		},
+	}
+	policy.Name = backendNetworkPolicyName(r.instance)
+
+	err := controllerutil.SetControllerReference(r.instance, policy, r.Scheme)
+	return policy, err
+}
+
+// ReconcileNetworkPolicy creates the NetworkPolicies of spec.networkPolicy
+// and deletes them once it is unset. The egress of pooled onions is
+// restricted by the policy of their pool.
+func (r *OnionServiceReconciler) ReconcileNetworkPolicy() error {
+	spec := r.instance.Spec.NetworkPolicy
+	// an empty selector would restrict every pod of the namespace
+	if spec == nil || len(r.instance.Spec.Backend.Selector) == 0 {
+		if err := r.deleteNetworkPolicy(torNetworkPolicyName(r.instance)); err != nil {
+			return err
+		}
+		return r.deleteNetworkPolicy(backendNetworkPolicyName(r.instance))
+	}
+
+	if r.instance.Spec.Pool != "" {
+		if err := r.deleteNetworkPolicy(torNetworkPolicyName(r.instance)); err != nil {
+			return err
+		}
+	} else {
+		policy, err := r.torNetworkPolicy()
+		if err != nil {
+			return err
+		}
+		if err := r.applyNetworkPolicy(policy); err != nil {
+			return err
+		}
+	}
+
+	policy, err := r.backendNetworkPolicy()
+	if err != nil {
+		return err
+	}
+	return r.applyNetworkPolicy(policy)
+}
+
+func (r *OnionServiceReconciler) applyNetworkPolicy(policy *networkingv1.NetworkPolicy) error {
+	found := &networkingv1.NetworkPolicy{}
+	if err := r.Get(r.ctx, types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace}, found); err != nil {
+		if errors.IsNotFound(err) {
+			r.Log.Info("Creating NetworkPolicy %s/%s\n", policy.Namespace, policy.Name)
+			return r.Create(r.ctx, policy)
+		}
+		return err
+	}
+
+	if !reflect.DeepEqual(policy.Spec, found.Spec) {
+		found.Spec = policy.Spec
+		r.Log.Info("Updating NetworkPolicy %s/%s\n", policy.Namespace, policy.Name)
+		return r.Update(r.ctx, found)
+	}
+
+	return nil
+}
+
+// deleteNetworkPolicy removes the policy name if it was created for the
+// instance.
+func (r *OnionServiceReconciler) deleteNetworkPolicy(name string) error {
+	found := &networkingv1.NetworkPolicy{}
+	if err := r.Get(r.ctx, types.NamespacedName{Name: name, Namespace: r.instance.Namespace}, found); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if !metav1.IsControlledBy(found, r.instance) {
+		return nil
+	}
+
+	r.Log.Info("Deleting NetworkPolicy %s/%s\n", found.Namespace, found.Name)
+	return r.Delete(r.ctx, found)
+}
+
+// poolNetworkPolicyName is the policy restricting the egress of the daemon
+// of the TorDaemonPool name.
+func poolNetworkPolicyName(name string) string {
+	return name + "-tor"
+}
+
+// reconcileNetworkPolicy restricts the egress of the daemon of the pool once
+// any of the members sets networkPolicy. The daemon reaches the backends of
+// all members, the private and API server ranges of the members are merged.
+func (r *TorDaemonPoolReconciler) reconcileNetworkPolicy() error {
+	var restricted bool
+	var except, apiServerCIDRs []string
+	seen := map[string]bool{}
+	for _, onion := range r.members {
+		spec := onion.Spec.NetworkPolicy
+		if spec == nil {
+			continue
+		}
+		restricted = true
+		for _, cidr := range spec.PrivateCIDRs {
+			if !seen["private/"+cidr] {
+				seen["private/"+cidr] = true
+				except = append(except, cidr)
+			}
+		}
+		for _, cidr := range spec.APIServerCIDRs {
+			if !seen["apiServer/"+cidr] {
+				seen["apiServer/"+cidr] = true
+				apiServerCIDRs = append(apiServerCIDRs, cidr)
+			}
+		}
+	}
+
+	name := types.NamespacedName{Name: poolNetworkPolicyName(r.instance.Name), Namespace: r.instance.Namespace}
+	found := &networkingv1.NetworkPolicy{}
+	err := r.Get(r.ctx, name, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if !restricted {
+		if exists && metav1.IsControlledBy(found, r.instance) {
+			r.Log.Info("Deleting NetworkPolicy %s/%s\n", found.Namespace, found.Name)
+			return r.Delete(r.ctx, found)
+		}
+		return nil
+	}
+
+	egress, err := torEgressRules(r.ctx, r, except, apiServerCIDRs, r.members)
+	if err != nil {
+		return err
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: *r.NewObjectMeta(),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: poolPodLabels(r.instance.Name)},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      egress,
+		},
+	}
+	policy.Name = name.Name
+
+	if !exists {
+		r.Log.Info("Creating NetworkPolicy %s/%s\n", policy.Namespace, policy.Name)
+		return r.Create(r.ctx, policy)
+	}
+
+	if !reflect.DeepEqual(policy.Spec, found.Spec) {
+		found.Spec = policy.Spec
+		r.Log.Info("Updating NetworkPolicy %s/%s\n", policy.Namespace, policy.Name)
+		return r.Update(r.ctx, found)
+	}
+
+	return nil
+}
@@ -0,0 +1,433 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/backup"
+	"github.com/marcus-sa/tor-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"os"
+	"path"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+const (
+	// BackupFailed is used as part of the Event 'reason' when a bundle
+	// cannot be written
+	BackupFailed = "BackupFailed"
+	// BackupSucceeded is used as part of the Event 'reason' when a bundle
+	// is written
+	BackupSucceeded = "BackupSucceeded"
+	// MessageBackupSucceeded is the message used for Events fired when a
+	// bundle is written
+	MessageBackupSucceeded = "Wrote keys of %d OnionServices to %s"
+	// KeyNotBackedUp is used as part of the Event 'reason' when the key of
+	// an OnionService cannot be backed up
+	KeyNotBackedUp = "KeyNotBackedUp"
+	// MessageKeyNotBackedUp is the message used for Events warning about
+	// keys generated by tor, which are not stored in a Secret
+	MessageKeyNotBackedUp = "Key of OnionService %s was generated by tor and is not stored in a Secret, set privateKeySecret to back it up"
+	// RestoreFailed is used as part of the Event 'reason' when a bundle
+	// cannot be restored
+	RestoreFailed = "RestoreFailed"
+	// Restored is used as part of the Event 'reason' when a bundle is
+	// restored
+	Restored = "Restored"
+	// MessageRestored is the message used for Events fired when a bundle is
+	// restored
+	MessageRestored = "Restored keys of %d OnionServices from %s"
+	// MessageSecretConflict is the message used for Events warning about
+	// existing Secrets that differ from the bundle
+	MessageSecretConflict = "Secret %q exists and differs from the bundle, it is left untouched"
+
+	// BackupDir is where the PersistentVolumeClaims of backup targets are
+	// mounted into the operator.
+	BackupDir = "/var/lib/tor-operator/backups"
+
+	defaultBackupInterval = 24 * time.Hour
+	// backupRetryInterval is how soon failed backups and restores are retried
+	backupRetryInterval = time.Minute
+	// passphraseKey holds the passphrase of the private key used to restore
+	passphraseKey = "passphrase"
+)
+
+// OnionKeyBackupReconciler reconciles a OnionKeyBackup object
+type OnionKeyBackupReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// recorder is an event recorder for recording Event resources to the
+	// Kubernetes API.
+	Recorder record.EventRecorder
+
+	ctx      context.Context
+	instance *torv1beta1.OnionKeyBackup
+}
+
+func backupInterval(spec *torv1beta1.OnionKeyBackupSpec) time.Duration {
+	if spec.Interval.Duration > 0 {
+		return spec.Interval.Duration
+	}
+	return defaultBackupInterval
+}
+
+// +kubebuilder:rbac:groups=tor.k8s.io,resources=onionkeybackups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.k8s.io,resources=onionkeybackups/status,verbs=get;update;patch
+func (r *OnionKeyBackupReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	r.ctx = context.Background()
+	log := r.Log.WithValues(req.Name, req.NamespacedName)
+
+	r.instance = &torv1beta1.OnionKeyBackup{}
+
+	if err := r.Get(r.ctx, req.NamespacedName, r.instance); err != nil {
+		log.Error(err, "unable to fetch OnionKeyBackup")
+
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	status := r.instance.Status.DeepCopy()
+	status.Message = ""
+
+	target, err := r.target()
+	if err != nil {
+		r.Recorder.Event(r.instance, corev1.EventTypeWarning, ErrInvalidSpec, err.Error())
+		status.Message = err.Error()
+		return ctrl.Result{}, r.updateStatus(status)
+	}
+
+	restoreFailed := false
+	if restore := r.instance.Spec.Restore; restore != nil && restore.Bundle != status.RestoredBundle {
+		if err := r.restore(target, restore); err != nil {
+			r.Recorder.Event(r.instance, corev1.EventTypeWarning, RestoreFailed, err.Error())
+			metrics.ReconcileErrors.WithLabelValues("OnionKeyBackup").Inc()
+			status.Message = err.Error()
+			restoreFailed = true
+		} else {
+			status.RestoredBundle = restore.Bundle
+		}
+	}
+
+	interval := backupInterval(&r.instance.Spec)
+	if last := status.LastBackupTime; last == nil || time.Since(last.Time) >= interval {
+		name, count, err := r.backup(target)
+		if err != nil {
+			r.Recorder.Event(r.instance, corev1.EventTypeWarning, BackupFailed, err.Error())
+			metrics.ReconcileErrors.WithLabelValues("OnionKeyBackup").Inc()
+			status.Message = err.Error()
+			// retry failed backups sooner than the interval
+			return ctrl.Result{RequeueAfter: backupRetryInterval}, r.updateStatus(status)
+		}
+
+		now := metav1.Now()
+		status.LastBackup = name
+		status.LastBackupTime = &now
+		status.OnionServices = count
+		r.Recorder.Event(r.instance, corev1.EventTypeNormal, BackupSucceeded, fmt.Sprintf(MessageBackupSucceeded, count, name))
+	}
+
+	if err := r.updateStatus(status); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// like backups, failed restores are retried sooner than the interval
+	requeue := time.Until(status.LastBackupTime.Add(interval))
+	if restoreFailed && backupRetryInterval < requeue {
+		requeue = backupRetryInterval
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+func (r *OnionKeyBackupReconciler) updateStatus(status *torv1beta1.OnionKeyBackupStatus) error {
+	if reflect.DeepEqual(*status, r.instance.Status) {
+		return nil
+	}
+
+	instanceCopy := r.instance.DeepCopy()
+	instanceCopy.Status = *status
+	return r.Status().Update(r.ctx, instanceCopy)
+}
+
+// target returns the target of the instance.
+func (r *OnionKeyBackupReconciler) target() (backup.Target, error) {
+	spec := r.instance.Spec.Target
+
+	switch {
+	case spec.PersistentVolumeClaim != nil && spec.S3 != nil:
+		return nil, fmt.Errorf("only one of target.persistentVolumeClaim and target.s3 can be set")
+
+	case spec.PersistentVolumeClaim != nil:
+		mount := path.Join(BackupDir, spec.PersistentVolumeClaim.ClaimName)
+		if _, err := os.Stat(mount); err != nil {
+			return nil, fmt.Errorf("PersistentVolumeClaim %q is not mounted into the operator at %s",
+				spec.PersistentVolumeClaim.ClaimName, mount)
+		}
+		return backup.Dir(path.Join(mount, path.Clean("/"+spec.PersistentVolumeClaim.Path))), nil
+
+	case spec.S3 != nil:
+		credentials := &corev1.Secret{}
+		name := types.NamespacedName{Name: spec.S3.CredentialsSecret, Namespace: r.instance.Namespace}
+		if err := r.Get(r.ctx, name, credentials); err != nil {
+			return nil, fmt.Errorf("unable to read S3 credentials: %v", err)
+		}
+		return &backup.S3{
+			Endpoint:        spec.S3.Endpoint,
+			Bucket:          spec.S3.Bucket,
+			Prefix:          spec.S3.Prefix,
+			Region:          spec.S3.Region,
+			AccessKeyID:     string(credentials.Data["accessKeyID"]),
+			SecretAccessKey: string(credentials.Data["secretAccessKey"]),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("one of target.persistentVolumeClaim and target.s3 has to be set")
+}
+
+// secretData reads the key ref of a Secret in the namespace of the instance.
+func (r *OnionKeyBackupReconciler) secretData(ref torv1beta1.SecretReference) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(r.ctx, types.NamespacedName{Name: ref.Name, Namespace: r.instance.Namespace}, secret); err != nil {
+		return nil, err
+	}
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("secret %q has no key %q", ref.Name, ref.Key)
+	}
+	return data, nil
+}
+
+// backupKeys returns the Secret holding the key onion is bound to and the
+// names of all Secrets with keys of onion, or nil if tor generated its key.
+func backupKeys(onion *torv1beta1.OnionService) (*torv1beta1.SecretReference, []string) {
+	var bound *torv1beta1.SecretReference
+	var names []string
+
+	if onion.Spec.PrivateKeySecret != nil {
+		bound = onion.Spec.PrivateKeySecret
+		names = append(names, bound.Name)
+	}
+	if onion.Spec.Migration != nil {
+		ref := migrationKeySecret(onion)
+		if onion.Spec.Version == 3 {
+			bound = ref
+		}
+		names = append(names, ref.Name)
+	}
+	if vanity := onion.Status.Vanity; vanity != nil && vanity.KeySecret != nil {
+		if onion.Spec.VanityPrefix != "" && !vanityPending(onion) {
+			bound = vanity.KeySecret
+		}
+		names = append(names, vanity.KeySecret.Name)
+	}
+	if rotation := onion.Status.KeyRotation; rotation != nil {
+		// the last rotated key is kept after keyRotation is removed, unless
+		// a vanity key replaced it
+		vanity := onion.Spec.VanityPrefix != "" && !vanityPending(onion)
+		if onion.Spec.KeyRotation != nil || !vanity {
+			bound = &rotation.KeySecret
+		}
+		names = append(names, rotation.KeySecret.Name)
+		if rotation.PreviousKeySecret != nil {
+			names = append(names, rotation.PreviousKeySecret.Name)
+		}
+	}
+
+	return bound, names
+}
+
+// backup writes the keys of all OnionServices in the namespace of the
+// instance into a new bundle and returns its name and the number of
+// OnionServices in it.
+func (r *OnionKeyBackupReconciler) backup(target backup.Target) (string, int, error) {
+	recipientsData, err := r.secretData(r.instance.Spec.RecipientsSecret)
+	if err != nil {
+		return "", 0, err
+	}
+	recipients, err := backup.ReadKeyRing(recipientsData)
+	if err != nil {
+		return "", 0, err
+	}
+
+	list := &torv1beta1.OnionServiceList{}
+	if err := r.List(r.ctx, list, client.InNamespace(r.instance.Namespace)); err != nil {
+		return "", 0, err
+	}
+
+	now := time.Now().UTC()
+	bundle := &backup.Bundle{
+		Version:   backup.BundleVersion,
+		Created:   now,
+		Namespace: r.instance.Namespace,
+	}
+
+	for i := range list.Items {
+		onion := &list.Items[i]
+		// keys of external stores are backed up by the store
+		if onion.Spec.KeyProvider != nil {
+			continue
+		}
+		bound, names := backupKeys(onion)
+		if bound == nil {
+			r.Recorder.Event(r.instance, corev1.EventTypeWarning, KeyNotBackedUp, fmt.Sprintf(MessageKeyNotBackedUp, onion.Name))
+			continue
+		}
+
+		entry := backup.OnionService{
+			Name:      onion.Name,
+			Hostname:  onion.Status.Hostname,
+			KeySecret: *bound,
+		}
+
+		seen := map[string]bool{}
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			secret := &corev1.Secret{}
+			if err := r.Get(r.ctx, types.NamespacedName{Name: name, Namespace: onion.Namespace}, secret); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return "", 0, err
+			}
+			entry.Secrets = append(entry.Secrets, backup.Secret{Name: name, Data: secret.Data})
+		}
+
+		bundle.OnionServices = append(bundle.OnionServices, entry)
+	}
+
+	data, err := backup.Encrypt(bundle, recipients)
+	if err != nil {
+		return "", 0, err
+	}
+
+	name := fmt.Sprintf("%s-%s.pgp", r.instance.Name, now.Format("20060102T150405Z"))
+	r.Log.Info("Writing bundle %s/%s\n", r.instance.Namespace, name)
+	if err := target.Put(r.ctx, name, data); err != nil {
+		return "", 0, err
+	}
+
+	return name, len(bundle.OnionServices), nil
+}
+
+// restore recreates the Secrets in a bundle and binds OnionServices without a
+// key of their own to the restored keys. Existing Secrets are never replaced.
+func (r *OnionKeyBackupReconciler) restore(target backup.Target, spec *torv1beta1.RestoreSpec) error {
+	privateKeyData, err := r.secretData(spec.PrivateKeySecret)
+	if err != nil {
+		return err
+	}
+	keyring, err := backup.ReadKeyRing(privateKeyData)
+	if err != nil {
+		return err
+	}
+	passphrase, _ := r.secretData(torv1beta1.SecretReference{Name: spec.PrivateKeySecret.Name, Key: passphraseKey})
+
+	data, err := target.Get(r.ctx, spec.Bundle)
+	if err != nil {
+		return err
+	}
+	bundle, err := backup.Decrypt(data, keyring, passphrase)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range bundle.OnionServices {
+		for _, s := range entry.Secrets {
+			if err := r.restoreSecret(s); err != nil {
+				return err
+			}
+		}
+
+		if err := r.bind(entry); err != nil {
+			return err
+		}
+	}
+
+	r.Recorder.Event(r.instance, corev1.EventTypeNormal, Restored, fmt.Sprintf(MessageRestored, len(bundle.OnionServices), spec.Bundle))
+	return nil
+}
+
+func (r *OnionKeyBackupReconciler) restoreSecret(s backup.Secret) error {
+	found := &corev1.Secret{}
+	err := r.Get(r.ctx, types.NamespacedName{Name: s.Name, Namespace: r.instance.Namespace}, found)
+	if err == nil {
+		if !reflect.DeepEqual(found.Data, s.Data) {
+			r.Recorder.Event(r.instance, corev1.EventTypeWarning, RestoreFailed, fmt.Sprintf(MessageSecretConflict, s.Name))
+		}
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	// not owned by the OnionKeyBackup, losing the key means losing the
+	// address
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.Name,
+			Namespace: r.instance.Namespace,
+		},
+		Data: s.Data,
+	}
+	r.Log.Info("Creating Secret %s/%s\n", secret.Namespace, secret.Name)
+	return r.Create(r.ctx, secret)
+}
+
+// bind points the OnionService of entry at its restored key, unless it uses
+// a key of its own.
+func (r *OnionKeyBackupReconciler) bind(entry backup.OnionService) error {
+	onion := &torv1beta1.OnionService{}
+	err := r.Get(r.ctx, types.NamespacedName{Name: entry.Name, Namespace: r.instance.Namespace}, onion)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if bound, _ := backupKeys(onion); bound != nil || onion.Spec.KeyProvider != nil {
+		return nil
+	}
+
+	onion.Spec.PrivateKeySecret = entry.KeySecret.DeepCopy()
+	r.Log.Info("Updating OnionService %s/%s\n", onion.Namespace, onion.Name)
+	return r.Update(r.ctx, onion)
+}
+
+func (r *OnionKeyBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&torv1beta1.OnionKeyBackup{}).
+		Complete(r)
+}
@@ -19,16 +19,26 @@ package controllers
 import (
 	"context"
 	"github.com/go-logr/logr"
-	torv1alpha1 "github.com/marcus-sa/tor-operator/api/v1alpha1"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/config"
+	"github.com/marcus-sa/tor-operator/pkg/keys"
+	"github.com/marcus-sa/tor-operator/pkg/metrics"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strconv"
+	"time"
 )
 
 const (
@@ -43,6 +53,15 @@ const (
 	// MessageResourceSynced is the message used for an Event fired when a Foo
 	// is synced successfully
 	MessageResourceSynced = "Foo synced successfully"
+	// ErrInvalidSpec is used as part of the Event 'reason' when an
+	// OnionService cannot be rolled out as specified
+	ErrInvalidSpec = "InvalidSpec"
+	// NonAnonymous is used as part of the Event 'reason' when a single onion
+	// service is synced
+	NonAnonymous = "NonAnonymous"
+	// MessageNonAnonymous is the message used for Events warning that a
+	// single onion service does not hide the server
+	MessageNonAnonymous = "OnionService runs in singleOnion mode, the location of the server is NOT hidden"
 )
 
 // OnionServiceReconciler reconciles a OnionService object
@@ -53,9 +72,23 @@ type OnionServiceReconciler struct {
 	// recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
 	Recorder record.EventRecorder
+	// VanityWorkers bounds the goroutines searching for vanity keys across
+	// all OnionServices, defaults to 1.
+	VanityWorkers int
 
 	ctx      context.Context
-	instance *torv1alpha1.OnionService
+	instance *torv1beta1.OnionService
+	// privateKey is the validated private key of the instance
+	privateKey *privateKey
+	// pendingHostnames holds the OnionServices seen without a hostname, so
+	// the time until it becomes available is only observed once
+	pendingHostnames map[types.UID]bool
+	// nonAnonymous holds the OnionServices warned about singleOnion mode
+	nonAnonymous map[types.UID]bool
+	// vanitySearches holds the running searches for vanity keys
+	vanitySearches map[types.NamespacedName]*vanitySearch
+	// vanityLimiter is shared by the vanity searches
+	vanityLimiter keys.VanityLimiter
 }
 
 func (r *OnionServiceReconciler) NewObjectMeta() *metav1.ObjectMeta {
@@ -70,8 +103,8 @@ func (r *OnionServiceReconciler) NewObjectMeta() *metav1.ObjectMeta {
 
 func (r *OnionServiceReconciler) NewOwnerReference() *metav1.OwnerReference {
 	return metav1.NewControllerRef(r.instance, schema.GroupVersionKind{
-		Group:   torv1alpha1.GroupVersion.Group,
-		Version: torv1alpha1.GroupVersion.Version,
+		Group:   torv1beta1.GroupVersion.Group,
+		Version: torv1beta1.GroupVersion.Version,
 		Kind:    "OnionService",
 	})
 }
@@ -85,69 +118,226 @@ func (r *OnionServiceReconciler) NewOwnerReference() *metav1.OwnerReference {
 // +kubebuilder:informers:group=core,version=v1,kind=Service
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:informers:group=core,version=v1,kind=Secret
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch
 // +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:informers:group=core,version=v1,kind=ServiceAccount
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=events,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
 func (r *OnionServiceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	r.ctx = context.Background()
 	log := r.Log.WithValues(req.Name, req.NamespacedName)
 
-	r.instance = &torv1alpha1.OnionService{}
+	r.instance = &torv1beta1.OnionService{}
 
 	if err := r.Get(r.ctx, req.NamespacedName, r.instance); err != nil {
 		log.Error(err, "unable to fetch OnionService")
 
 		if errors.IsNotFound(err) {
+			r.cancelVanitySearch(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 
 		return ctrl.Result{}, err
 	}
 
+	// the header of a deleted onion is removed before it is released
+	if r.instance.DeletionTimestamp != nil {
+		if err := r.FinalizeOnionLocation(); err != nil {
+			metrics.ReconcileErrors.WithLabelValues("Ingress").Inc()
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.ReconcileOnionLocationFinalizer(); err != nil {
+		metrics.ReconcileErrors.WithLabelValues("OnionService").Inc()
+		return ctrl.Result{}, err
+	}
+
+	r.observeHostname()
+
+	// the daemon would refuse to render the torrc, so don't roll it out.
+	// Version 2 onions are reported by the Deprecated condition instead.
+	_, specErr := config.CreateTorConfigForService(r.instance, "")
+	if specErr == nil {
+		specErr = validateOnionService(r.instance)
+	}
+	if specErr != nil && specErr != config.ErrVersion2 {
+		r.Recorder.Event(r.instance, corev1.EventTypeWarning, ErrInvalidSpec, specErr.Error())
+		return ctrl.Result{}, nil
+	}
+
+	// warn once the mode changes instead of on every reconcile
+	if r.nonAnonymous == nil {
+		r.nonAnonymous = map[types.UID]bool{}
+	}
+	if r.instance.Spec.Mode != torv1beta1.SingleOnionMode {
+		delete(r.nonAnonymous, r.instance.UID)
+	} else if !r.nonAnonymous[r.instance.UID] {
+		r.nonAnonymous[r.instance.UID] = true
+		r.Recorder.Event(r.instance, corev1.EventTypeWarning, NonAnonymous, MessageNonAnonymous)
+	}
+
 	var errs []error
 
-	if err := r.ReconcileServiceAccount(req); err != nil {
+	// pooled onions use the daemon and service account of their pool
+	if r.instance.Spec.Pool == "" {
+		if err := r.ReconcileServiceAccount(req); err != nil {
+			errs = append(errs, err)
+			metrics.ReconcileErrors.WithLabelValues("ServiceAccount").Inc()
+			//return ctrl.Result{}, err
+		}
+
+		if err := r.ReconcileRole(req); err != nil {
+			errs = append(errs, err)
+			metrics.ReconcileErrors.WithLabelValues("Role").Inc()
+			//return ctrl.Result{}, err
+		}
+
+		if err := r.ReconcileRoleBinding(req); err != nil {
+			errs = append(errs, err)
+			metrics.ReconcileErrors.WithLabelValues("RoleBinding").Inc()
+			//return ctrl.Result{}, err
+		}
+	}
+
+	if err := r.ReconcileService(req); err != nil {
 		errs = append(errs, err)
+		metrics.ReconcileErrors.WithLabelValues("Service").Inc()
 		//return ctrl.Result{}, err
 	}
 
-	if err := r.ReconcileRole(req); err != nil {
+	if err := r.ReconcileNetworkPolicy(); err != nil {
 		errs = append(errs, err)
+		metrics.ReconcileErrors.WithLabelValues("NetworkPolicy").Inc()
 		//return ctrl.Result{}, err
 	}
 
-	if err := r.ReconcileRoleBinding(req); err != nil {
+	// the key of a migration has to exist before the daemon mounts it
+	if err := r.ReconcileMigration(); err != nil {
 		errs = append(errs, err)
+		metrics.ReconcileErrors.WithLabelValues("Secret").Inc()
 		//return ctrl.Result{}, err
 	}
 
-	if err := r.ReconcileService(req); err != nil {
+	// the rotated or vanity key replaces the referenced one
+	keyErr := r.ReconcileKeyRotation()
+	if keyErr == nil {
+		keyErr = r.ReconcileVanity()
+	}
+	if keyErr == nil {
+		keyErr = r.ReconcilePrivateKey()
+	}
+	if keyErr != nil {
+		errs = append(errs, keyErr)
+		metrics.ReconcileErrors.WithLabelValues("Secret").Inc()
+		//return ctrl.Result{}, err
+	}
+
+	// a daemon without a usable key would publish a different address, one
+	// of a version 2 onion would not start at all
+	if specErr == nil && keyErr == nil && findCondition(&r.instance.Status, torv1beta1.OnionServiceKeyInvalid) == nil &&
+		!vanityPending(r.instance) {
+		if err := r.ReconcileDeployment(req); err != nil {
+			errs = append(errs, err)
+			metrics.ReconcileErrors.WithLabelValues("Deployment").Inc()
+			//return ctrl.Result{}, err
+		}
+	}
+
+	// the Pooled condition is reported by the pool of the instance
+	if r.instance.Spec.Pool == "" {
+		removeCondition(&r.instance.Status, torv1beta1.OnionServicePooled)
+	}
+
+	// retired keys are deleted once the daemon no longer mounts them
+	retiring, err := r.deleteRetiredKeys()
+	if err != nil {
+		errs = append(errs, err)
+		metrics.ReconcileErrors.WithLabelValues("Secret").Inc()
+		//return ctrl.Result{}, err
+	}
+
+	if err := r.ReconcilePodDisruptionBudget(req); err != nil {
+		errs = append(errs, err)
+		metrics.ReconcileErrors.WithLabelValues("PodDisruptionBudget").Inc()
+		//return ctrl.Result{}, err
+	}
+
+	if err := r.ReconcileIngress(); err != nil {
 		errs = append(errs, err)
+		metrics.ReconcileErrors.WithLabelValues("Ingress").Inc()
 		//return ctrl.Result{}, err
 	}
 
-	if err := r.ReconcileDeployment(req); err != nil {
+	if err := r.ReconcileHostnameTarget(); err != nil {
 		errs = append(errs, err)
+		metrics.ReconcileErrors.WithLabelValues(r.instance.Spec.HostnameTarget.Kind).Inc()
 		//return ctrl.Result{}, err
 	}
 
 	// Finally, we update the status block of the OnionService resource to reflect the
 	// current state of the world
 	if err := r.UpdateServiceStatus(req); err != nil {
+		metrics.ReconcileErrors.WithLabelValues("OnionService").Inc()
 		return ctrl.Result{}, err
 	}
 
 	r.Recorder.Event(r.instance, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
 
-	return ctrl.Result{}, nil
+	// retire the previous key of a rotation once the overlap is over and
+	// report the progress of vanity key searches
+	requeue := r.retireAfter()
+	if retiring && (requeue == 0 || retiredKeyRequeue < requeue) {
+		requeue = retiredKeyRequeue
+	}
+	if d := r.vanityRequeue(); d > 0 && (requeue == 0 || d < requeue) {
+		requeue = d
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// observeHostname records how long it took for the hostname of the instance to
+// become available once the daemon publishes it.
+func (r *OnionServiceReconciler) observeHostname() {
+	if r.pendingHostnames == nil {
+		r.pendingHostnames = map[types.UID]bool{}
+	}
+
+	uid := r.instance.UID
+	if r.instance.Status.Hostname == "" {
+		r.pendingHostnames[uid] = true
+		return
+	}
+
+	if !r.pendingHostnames[uid] {
+		return
+	}
+	delete(r.pendingHostnames, uid)
+
+	metrics.HostnameLatency.Observe(time.Since(r.instance.CreationTimestamp.Time).Seconds())
+
+	// without a private key tor generated a new one on startup
+	if pk, err := readPrivateKey(r.ctx, r, r.instance); err == nil && pk == nil {
+		metrics.KeysGenerated.WithLabelValues(strconv.Itoa(r.instance.Spec.Version), metrics.KeySourceTor).Inc()
+	}
 }
 
 func (r *OnionServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&torv1alpha1.OnionService{}).
+		For(&torv1beta1.OnionService{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&corev1.Secret{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&policyv1beta1.PodDisruptionBudget{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.onionsOfSecret)}).
 		Complete(r)
 }
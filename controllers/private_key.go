@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/keyprovider"
+	"github.com/marcus-sa/tor-operator/pkg/keys"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// KeyInvalid is used as part of the Event 'reason' when the private key
+	// of an OnionService cannot be used
+	KeyInvalid = "KeyInvalid"
+	// MessageKeyInvalid is the message used for Events and conditions of
+	// private keys that cannot be used
+	MessageKeyInvalid = "Private key in Secret %q is invalid: %v"
+)
+
+// privateKey is the private key of an OnionService as mounted into its daemon.
+type privateKey struct {
+	// secret is the Secret mounted into the daemon
+	secret *torv1beta1.SecretReference
+	// key is the parsed version 3 key, version 2 keys are not checked
+	key *keys.Key
+	// format of the key in the referenced Secret
+	format string
+	// reason and invalid explain why the key cannot be used
+	reason  string
+	invalid error
+	// external keys are fetched by an init container from the store of
+	// the KeyProvider instead of being mounted from secret
+	external bool
+}
+
+// importedKeySecret references the Secret holding the key of onion converted
+// into the format of tor.
+func importedKeySecret(onion *torv1beta1.OnionService) *torv1beta1.SecretReference {
+	return &torv1beta1.SecretReference{
+		Name: onion.Name + "-tor-key",
+		Key:  keys.SecretKeyFileName,
+	}
+}
+
+// readPrivateKey reads and validates the private key of onion. It returns nil
+// when tor generates the key. Keys not in the format of tor are mounted from
+// the Secret they are imported into by the OnionService controller. Keys of
+// external stores are only read by the daemon.
+func readPrivateKey(ctx context.Context, c client.Reader, onion *torv1beta1.OnionService) (*privateKey, error) {
+	if provider := onion.Spec.KeyProvider; provider != nil && provider.Vault != nil {
+		return &privateKey{external: true}, nil
+	}
+
+	// the key generated by a rotation replaces the referenced one
+	rotation := onion.Status.KeyRotation
+	if onion.Spec.KeyRotation != nil && rotation != nil {
+		return &privateKey{secret: rotation.KeySecret.DeepCopy()}, nil
+	}
+	if vanity := onion.Status.Vanity; onion.Spec.VanityPrefix != "" && !vanityPending(onion) {
+		return &privateKey{secret: vanity.KeySecret.DeepCopy()}, nil
+	}
+	// the last rotated key is kept after keyRotation is removed
+	if rotation != nil {
+		return &privateKey{secret: rotation.KeySecret.DeepCopy()}, nil
+	}
+
+	// after the cut over the key generated for the migration is used, a
+	// privateKeySecret left over holds the version 2 key
+	if onion.Spec.Version == 3 && onion.Spec.Migration != nil {
+		return &privateKey{secret: migrationKeySecret(onion)}, nil
+	}
+
+	ref := onion.Spec.PrivateKeySecret
+	if ref == nil {
+		return nil, nil
+	}
+
+	pk := &privateKey{secret: ref}
+
+	data, err := keyprovider.ForOnionService(c, onion).Fetch(ctx)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			pk.reason, pk.invalid = "SecretNotFound", fmt.Errorf("secret does not exist")
+			return pk, nil
+		}
+		if goerrors.Is(err, keyprovider.ErrKeyNotFound) {
+			pk.reason, pk.invalid = "KeyNotFound", fmt.Errorf("secret has no key %q", ref.Key)
+			return pk, nil
+		}
+		return nil, err
+	}
+
+	if onion.Spec.Version != 3 {
+		return pk, nil
+	}
+
+	key, format, err := keys.ParseSecretKey(data)
+	if err != nil {
+		pk.reason, pk.invalid = "InvalidFormat", err
+		return pk, nil
+	}
+
+	pk.key, pk.format = key, format
+	if format != keys.FormatTor {
+		pk.secret = importedKeySecret(onion)
+	}
+	return pk, nil
+}
+
+// ReconcilePrivateKey validates the private key of the instance, imports keys
+// not in the format of tor and records invalid keys in the KeyInvalid
+// condition. The Deployment is not rolled out while the condition is set.
+func (r *OnionServiceReconciler) ReconcilePrivateKey() error {
+	pk, err := readPrivateKey(r.ctx, r, r.instance)
+	if err != nil {
+		return err
+	}
+	r.privateKey = pk
+
+	status := &r.instance.Status
+	if pk != nil && pk.invalid != nil {
+		msg := fmt.Sprintf(MessageKeyInvalid, pk.secret.Name, pk.invalid)
+		r.Recorder.Event(r.instance, corev1.EventTypeWarning, KeyInvalid, msg)
+		setCondition(status, torv1beta1.OnionServiceKeyInvalid, corev1.ConditionTrue, pk.reason, msg)
+		status.ExpectedHostname = ""
+		return nil
+	}
+	removeCondition(status, torv1beta1.OnionServiceKeyInvalid)
+
+	if pk == nil || pk.key == nil {
+		status.ExpectedHostname = ""
+		return nil
+	}
+
+	// the address is known before the daemon publishes it in hostname
+	status.ExpectedHostname = pk.key.Hostname()
+
+	if pk.format == keys.FormatTor {
+		return nil
+	}
+	return r.importKey(pk)
+}
+
+// importKey writes the key of pk into the Secret mounted into the daemon.
+func (r *OnionServiceReconciler) importKey(pk *privateKey) error {
+	secret := &corev1.Secret{
+		ObjectMeta: *r.NewObjectMeta(),
+		Data: map[string][]byte{
+			pk.secret.Key: pk.key.SecretKeyFile(),
+		},
+	}
+	secret.Name = pk.secret.Name
+
+	if err := controllerutil.SetControllerReference(r.instance, secret, r.Scheme); err != nil {
+		return err
+	}
+
+	found := &corev1.Secret{}
+	if err := r.Get(r.ctx, types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}, found); err != nil {
+		if errors.IsNotFound(err) {
+			r.Log.Info("Creating Secret %s/%s\n", secret.Namespace, secret.Name)
+			return r.Create(r.ctx, secret)
+		}
+		return err
+	}
+
+	if !reflect.DeepEqual(secret.Data, found.Data) {
+		found.Data = secret.Data
+		r.Log.Info("Updating Secret %s/%s\n", secret.Namespace, secret.Name)
+		return r.Update(r.ctx, found)
+	}
+
+	return nil
+}
@@ -1,28 +1,38 @@
 package controllers
 
 import (
-	torv1alpha1 "github.com/marcus-sa/tor-operator/api/v1alpha1"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+// torDaemonRules are the permissions of the tor daemon manager.
+func torDaemonRules() []rbacv1.PolicyRule {
+	return []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{torv1beta1.GroupVersion.Group},
+			Verbs: []string{"get", "list", "watch", "update", "patch"},
+			Resources: []string{"onionservices"},
+		},
+		{
+			APIGroups: []string{torv1beta1.GroupVersion.Group},
+			Verbs: []string{"get", "update", "patch"},
+			Resources: []string{"onionservices/status"},
+		},
+		{
+			APIGroups: []string{""},
+			Verbs: []string{"create", "update", "patch"},
+			Resources: []string{"events"},
+		},
+	}
+}
+
 func (r *OnionServiceReconciler) torRole() *rbacv1.Role  {
 	return &rbacv1.Role{
 		ObjectMeta: *r.NewObjectMeta(),
-		Rules: []rbacv1.PolicyRule{
-			{
-				APIGroups: []string{torv1alpha1.GroupVersion.Group},
-				Verbs: []string{"get", "list", "watch", "update", "patch"},
-				Resources: []string{"onionservices"},
-			},
-			{
-				APIGroups: []string{""},
-				Verbs: []string{"create", "update", "patch"},
-				Resources: []string{"events"},
-			},
-		},
+		Rules:      torDaemonRules(),
 	}
 }
 
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// privateKeyHashAnnotation holds a hash of the keys mounted into a daemon on
+// its pod template, so changing a key rolls out the daemon.
+const privateKeyHashAnnotation = "tor.k8s.io/private-key-hash"
+
+// referencesSecret returns whether onion uses the key in the Secret name,
+// including the Secret its key is imported into.
+func referencesSecret(onion *torv1beta1.OnionService, name string) bool {
+	if ref := onion.Spec.PrivateKeySecret; ref != nil && (ref.Name == name || importedKeySecret(onion).Name == name) {
+		return true
+	}
+	if onion.Spec.Migration != nil && migrationKeySecret(onion).Name == name {
+		return true
+	}
+	if rotation := onion.Status.KeyRotation; rotation != nil && rotation.KeySecret.Name == name {
+		return true
+	}
+	if vanity := onion.Status.Vanity; onion.Spec.VanityPrefix != "" && vanity != nil && vanity.KeySecret != nil &&
+		vanity.KeySecret.Name == name {
+		return true
+	}
+	return false
+}
+
+// onionsReferencing lists the OnionServices using the key in the Secret obj.
+func onionsReferencing(c client.Reader, obj handler.MapObject) []torv1beta1.OnionService {
+	list := &torv1beta1.OnionServiceList{}
+	if err := c.List(context.Background(), list, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		return nil
+	}
+
+	var onions []torv1beta1.OnionService
+	for _, onion := range list.Items {
+		if referencesSecret(&onion, obj.Meta.GetName()) {
+			onions = append(onions, onion)
+		}
+	}
+	return onions
+}
+
+// privateKeyHash hashes the Secret keys mounted by volumeMounts, keys of
+// other volumes are ignored.
+func privateKeyHash(ctx context.Context, c client.Reader, namespace string,
+	volumes []corev1.Volume, volumeMounts []corev1.VolumeMount) (string, error) {
+	secretNames := map[string]string{}
+	for _, volume := range volumes {
+		if volume.Secret != nil {
+			secretNames[volume.Name] = volume.Secret.SecretName
+		}
+	}
+
+	hash := sha256.New()
+	for _, mount := range volumeMounts {
+		name, ok := secretNames[mount.Name]
+		if !ok {
+			continue
+		}
+
+		secret := &corev1.Secret{}
+		if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, secret); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s/%s=", name, mount.SubPath)
+		hash.Write(secret.Data[mount.SubPath])
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// onionsOfSecret maps a Secret to the OnionServices with a daemon of their
+// own that use its key.
+func (r *OnionServiceReconciler) onionsOfSecret(obj handler.MapObject) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, onion := range onionsReferencing(r, obj) {
+		if onion.Spec.Pool != "" {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: onion.Name, Namespace: onion.Namespace},
+		})
+	}
+	return requests
+}
+
+// poolsOfSecret maps a Secret to the TorDaemonPools hosting OnionServices
+// that use its key.
+func (r *TorDaemonPoolReconciler) poolsOfSecret(obj handler.MapObject) []reconcile.Request {
+	seen := map[string]bool{}
+	var requests []reconcile.Request
+	for _, onion := range onionsReferencing(r, obj) {
+		if onion.Spec.Pool == "" || seen[onion.Spec.Pool] {
+			continue
+		}
+		seen[onion.Spec.Pool] = true
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: onion.Spec.Pool, Namespace: onion.Namespace},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/config"
+	"github.com/marcus-sa/tor-operator/pkg/metrics"
+	"github.com/marcus-sa/tor-operator/pkg/selftest"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSelfTestInterval = 5 * time.Minute
+	// building circuits to an onion regularly takes tens of seconds
+	selfTestTimeout = time.Minute
+
+	// SelfTestSucceeded is used as the reason of the Reachable condition
+	// when the onion answered on all public ports
+	SelfTestSucceeded = "SelfTestSucceeded"
+	// SelfTestFailed is used as the reason of the Reachable condition when
+	// at least one public port could not be reached
+	SelfTestFailed = "SelfTestFailed"
+)
+
+// selfTestRunner runs the reachability checks in the background and keeps
+// the outcome of the last complete run.
+type selfTestRunner struct {
+	mu       sync.Mutex
+	running  bool
+	last     time.Time
+	failures []string
+}
+
+func selfTestInterval(spec *torv1beta1.SelfTestSpec) time.Duration {
+	if spec.Interval.Duration > 0 {
+		return spec.Interval.Duration
+	}
+	return defaultSelfTestInterval
+}
+
+// start launches a run if the previous one finished at least interval ago.
+// done is called once the run is complete.
+func (t *selfTestRunner) start(onion *torv1beta1.OnionService, done func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.running || time.Since(t.last) < selfTestInterval(onion.Spec.SelfTest) {
+		return
+	}
+	t.running = true
+
+	onion = onion.DeepCopy()
+	go func() {
+		failures := runSelfTest(onion)
+
+		t.mu.Lock()
+		t.running = false
+		t.last = time.Now()
+		t.failures = failures
+		t.mu.Unlock()
+
+		done()
+	}()
+}
+
+// result returns the status of the Reachable condition for the last run,
+// ok is false if no run completed yet.
+func (t *selfTestRunner) result() (status corev1.ConditionStatus, reason, message string, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.last.IsZero() {
+		return "", "", "", false
+	}
+
+	if len(t.failures) > 0 {
+		return corev1.ConditionFalse, SelfTestFailed, strings.Join(t.failures, "; "), true
+	}
+	return corev1.ConditionTrue, SelfTestSucceeded, fmt.Sprintf("Reached at %s", t.last.Format(time.RFC3339)), true
+}
+
+// runSelfTest checks every public port of onion and returns the failures.
+func runSelfTest(onion *torv1beta1.OnionService) []string {
+	var failures []string
+
+	for _, port := range onion.Spec.Backend.Ports {
+		labels := []string{onion.Name, onion.Namespace, fmt.Sprint(port.PublicPort)}
+
+		ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+		started := time.Now()
+		err := selftest.Check(ctx, config.SocksPort, onion.Status.Hostname, port.PublicPort, onion.Spec.SelfTest.Protocol)
+		cancel()
+
+		metrics.SelfTestDuration.WithLabelValues(labels...).Observe(time.Since(started).Seconds())
+		if err != nil {
+			metrics.SelfTestSuccess.WithLabelValues(labels...).Set(0)
+			failures = append(failures, fmt.Sprintf("port %d: %v", port.PublicPort, err))
+			continue
+		}
+		metrics.SelfTestSuccess.WithLabelValues(labels...).Set(1)
+	}
+
+	return failures
+}
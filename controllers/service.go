@@ -1,14 +1,33 @@
 package controllers
 
 import (
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
 )
 
+const (
+	// proxyProtocolAnnotation marks Services whose ports receive a PROXY
+	// protocol header from tor in front of every connection
+	proxyProtocolAnnotation = "tor.k8s.io/proxy-protocol"
+
+	// ProxyProtocol is used as part of the Event 'reason' when the backend
+	// Service is synced with PROXY protocol enabled
+	ProxyProtocol = "ProxyProtocol"
+	// MessageProxyProtocol is the message used for Events listing the
+	// backend ports that have to accept the PROXY protocol
+	MessageProxyProtocol = "Backends must accept PROXY protocol v1 on ports %s"
+)
+
+func (r *OnionServiceReconciler) exportsCircuitID() bool {
+	options := r.instance.Spec.TorOptions
+	return options != nil && options.ExportCircuitID == torv1beta1.ExportCircuitIDHAProxy
+}
+
 func (r *OnionServiceReconciler) UpdateServiceStatus(req ctrl.Request) error {
 	instanceCopy := r.instance.DeepCopy()
 	service := &corev1.Service{}
@@ -23,17 +42,17 @@ func (r *OnionServiceReconciler) UpdateServiceStatus(req ctrl.Request) error {
 	}
 
 	instanceCopy.Status.TargetClusterIP = clusterIP
-	err = r.Update(r.ctx, instanceCopy)
+	err = r.Status().Update(r.ctx, instanceCopy)
 	return err
 }
 
 func (r *OnionServiceReconciler) torService() (*corev1.Service, error) {
 	var ports []corev1.ServicePort
-	for _, p := range r.instance.Spec.Ports {
+	for _, p := range r.instance.Spec.Backend.Ports {
 		port := corev1.ServicePort{
 			Name:       p.Name,
-			TargetPort: intstr.FromInt(int(p.TargetPort)),
-			Port:       p.TargetPort,
+			TargetPort: p.BackendTargetPort(),
+			Port:       p.BackendPort(),
 		}
 		ports = append(ports, port)
 	}
@@ -41,11 +60,17 @@ func (r *OnionServiceReconciler) torService() (*corev1.Service, error) {
 	service := &corev1.Service{
 		ObjectMeta: *r.NewObjectMeta(),
 		Spec: corev1.ServiceSpec{
-			Selector: r.instance.Spec.Selector,
+			Selector: r.instance.Spec.Backend.Selector,
 			Ports:    ports,
 		},
 	}
 
+	if r.exportsCircuitID() {
+		service.Annotations = map[string]string{
+			proxyProtocolAnnotation: "v1",
+		}
+	}
+
 	err := controllerutil.SetControllerReference(r.instance, service, r.Scheme)
 	return service, err
 }
@@ -59,6 +84,8 @@ func (r *OnionServiceReconciler) ReconcileService(req ctrl.Request) error {
 			if err := r.Create(r.ctx, service); err != nil {
 				return err
 			}
+			r.recordProxyProtocol(service)
+			return nil
 		}
 
 		return err
@@ -70,13 +97,42 @@ func (r *OnionServiceReconciler) ReconcileService(req ctrl.Request) error {
 	//	return fmt.Errorf(msg)
 	//}
 
-	if !reflect.DeepEqual(service.Spec, found.Spec) {
+	proxyProtocolChanged := found.Annotations[proxyProtocolAnnotation] != service.Annotations[proxyProtocolAnnotation]
+
+	if !reflect.DeepEqual(service.Spec, found.Spec) || proxyProtocolChanged {
 		found.Spec = service.Spec
+		if service.Annotations[proxyProtocolAnnotation] != "" {
+			if found.Annotations == nil {
+				found.Annotations = map[string]string{}
+			}
+			found.Annotations[proxyProtocolAnnotation] = service.Annotations[proxyProtocolAnnotation]
+		} else {
+			delete(found.Annotations, proxyProtocolAnnotation)
+		}
 		r.Log.Info("Updating Service %s/%s\n", service.Namespace, service.Name)
 		if err := r.Update(r.ctx, found); err != nil {
 			return err
 		}
+
+		if proxyProtocolChanged {
+			r.recordProxyProtocol(service)
+		}
 	}
 
 	return nil
 }
+
+// recordProxyProtocol tells which backend ports receive PROXY protocol
+// headers, tor cannot detect backends that do not understand them.
+func (r *OnionServiceReconciler) recordProxyProtocol(service *corev1.Service) {
+	if !r.exportsCircuitID() {
+		return
+	}
+
+	var ports []string
+	for _, p := range service.Spec.Ports {
+		ports = append(ports, p.TargetPort.String())
+	}
+	r.Recorder.Eventf(r.instance, corev1.EventTypeNormal, ProxyProtocol,
+		MessageProxyProtocol, strings.Join(ports, ", "))
+}
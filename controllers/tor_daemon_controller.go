@@ -4,76 +4,231 @@ import (
 	"context"
 	"fmt"
 	"github.com/go-logr/logr"
-	torv1alpha1 "github.com/marcus-sa/tor-operator/api/v1alpha1"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
 	"github.com/marcus-sa/tor-operator/pkg/config"
+	"github.com/marcus-sa/tor-operator/pkg/control"
 	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"os"
 	"os/exec"
+	"path"
+	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 )
 
+const (
+	torConfigPath = "/run/tor/torfile"
+
+	// DescriptorPublished is used as part of the Event 'reason' when an
+	// upload round of the descriptor completed
+	DescriptorPublished = "DescriptorPublished"
+	// DescriptorUploadFailed is used as part of the Event 'reason' when no
+	// HSDir accepted the descriptor in an upload round
+	DescriptorUploadFailed = "DescriptorUploadFailed"
+)
+
 type TorDaemonReconciler struct {
 	client.Client
 	Log                   logr.Logger
 	Scheme                *runtime.Scheme
+	Recorder              record.EventRecorder
 	OnionServiceNamespace string
 	OnionServiceName      string
-	cmd                   *exec.Cmd
+	// PoolName makes the daemon host all OnionServices of the TorDaemonPool
+	// instead of the single OnionService named OnionServiceName.
+	PoolName string
+	// ControlPassword protects the control port instead of cookie
+	// authentication when set.
+	ControlPassword       string
+	hashedControlPassword string
+	started               bool
+	processMu             sync.Mutex
+	alive                 bool
+	control               *control.Conn
+	descriptors           descriptorTracker
+	externalEvents        chan event.GenericEvent
+	selfTests             selfTestRunner
+	lastGoodConfig        string
 	ctx                   context.Context
-	instance              *torv1alpha1.OnionService
-	//metricsExporter 	  *metrics.TorDaemonMetricsExporter
+	instance              *torv1beta1.OnionService
 }
 
 func (r *TorDaemonReconciler) start() {
+	r.started = true
 	go func() {
 		for {
 			fmt.Println("Starting tor...")
-			r.cmd = exec.CommandContext(r.ctx,
+			// tor has to outlive the context of the reconcile that started it
+			cmd := exec.Command(
 				"tor",
-				"-f", "/run/tor/torfile",
+				"-f", torConfigPath,
 				"--allow-missing-torrc",
 			)
-			r.cmd.Stdout = os.Stdout
-			r.cmd.Stderr = os.Stderr
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
 
-			err := r.cmd.Start()
-			if err != nil {
+			if err := cmd.Start(); err != nil {
 				fmt.Print(err)
+			} else {
+				r.setAlive(true)
+				cmd.Wait()
+				r.setAlive(false)
 			}
-			r.cmd.Wait()
 			time.Sleep(time.Second * 1)
 		}
 	}()
 }
 
-func (r *TorDaemonReconciler) reload() {
+func (r *TorDaemonReconciler) setAlive(alive bool) {
+	r.processMu.Lock()
+	defer r.processMu.Unlock()
+	r.alive = alive
+}
+
+// running reports whether the supervised tor process is currently alive.
+func (r *TorDaemonReconciler) running() bool {
+	r.processMu.Lock()
+	defer r.processMu.Unlock()
+	return r.alive
+}
+
+func (r *TorDaemonReconciler) reload(torConfig string) error {
 	fmt.Println("Reloading Tor daemon...")
 
-	// start if not already running
-	if r.cmd == nil || (r.cmd.ProcessState != nil && r.cmd.ProcessState.Exited()) {
+	// start if not already running, tor reads the torfile on startup
+	if !r.started {
 		r.start()
-	} else {
-		r.cmd.Process.Signal(syscall.SIGHUP)
+		return nil
+	}
+
+	// tor is being restarted and will pick up the torfile by itself
+	if !r.running() {
+		return nil
+	}
+
+	if err := r.connect(); err != nil {
+		return err
 	}
+
+	if err := r.control.LoadConf(torConfig); err != nil {
+		// drop the connection so the next reload starts from a clean state,
+		// tor may have been restarted in the meantime
+		r.control.Close()
+		r.control = nil
+		return err
+	}
+
+	return nil
 }
 
-func (r *TorDaemonReconciler) syncOnionConfig() error {
-	torConfig, err := config.CreateTorConfigForService(r.instance)
+// connect opens the control connection to tor unless it is still open and
+// follows the descriptor uploads on it.
+func (r *TorDaemonReconciler) connect() error {
+	if r.control != nil && r.descriptors.isWatching() {
+		return nil
+	}
+
+	// tor went away since the last connection
+	if r.control != nil {
+		r.control.Close()
+		r.control = nil
+	}
+
+	conn, err := control.Dial(config.ControlSocket, "", r.ControlPassword)
 	if err != nil {
-		fmt.Printf("Generating config failed with %v\n", err)
 		return err
 	}
 
+	if err := conn.SetEvents("HS_DESC"); err != nil {
+		conn.Close()
+		return err
+	}
+	conn.StartAsyncReader()
+
+	r.descriptors.setWatching(true)
+	go r.watchDescriptors(conn)
+
+	r.control = conn
+	return nil
+}
+
+// watchDescriptors feeds HS_DESC events into the descriptor tracker and
+// queues a reconcile whenever an upload round completes.
+func (r *TorDaemonReconciler) watchDescriptors(conn *control.Conn) {
+	defer r.descriptors.setWatching(false)
+
+	for {
+		resp, err := conn.NextEvent()
+		if err != nil {
+			return
+		}
+
+		hsDesc, ok := control.ParseHSDescEvent(resp.Reply)
+		if !ok || !r.descriptors.handle(hsDesc) {
+			continue
+		}
+
+		r.enqueue()
+	}
+}
+
+// enqueue queues a reconcile of the OnionService from outside the watch.
+func (r *TorDaemonReconciler) enqueue() {
+	name := r.OnionServiceName
+	if r.PoolName != "" {
+		name = r.PoolName
+	}
+
+	r.externalEvents <- event.GenericEvent{
+		Meta: &metav1.ObjectMeta{
+			Name:      name,
+			Namespace: r.OnionServiceNamespace,
+		},
+	}
+}
+
+// rollbackConfig restores the last torfile tor accepted so a restart of the
+// daemon does not pick up a rejected configuration.
+func (r *TorDaemonReconciler) rollbackConfig() error {
+	if r.lastGoodConfig == "" {
+		return nil
+	}
+
+	fmt.Println("rolling back tor config")
+	return ioutil.WriteFile(torConfigPath, []byte(r.lastGoodConfig), 0644)
+}
+
+// hashControlPassword derives the HashedControlPassword for the torfile.
+func (r *TorDaemonReconciler) hashControlPassword() error {
+	// the hash is salted, only derive it once to keep the torfile stable
+	if r.ControlPassword != "" && r.hashedControlPassword == "" {
+		hashed, err := control.HashPassword(r.ControlPassword)
+		if err != nil {
+			return err
+		}
+		r.hashedControlPassword = hashed
+	}
+	return nil
+}
+
+// applyConfig writes torConfig to the torfile and makes tor load it if it
+// changed.
+func (r *TorDaemonReconciler) applyConfig(torConfig string) error {
 	reload := false
 
-	torfile, err := ioutil.ReadFile("/run/tor/torfile")
+	torfile, err := ioutil.ReadFile(torConfigPath)
 	if os.IsNotExist(err) {
 		reload = true
 	} else if err != nil {
@@ -85,15 +240,40 @@ func (r *TorDaemonReconciler) syncOnionConfig() error {
 	}
 
 	if reload {
-		fmt.Printf("updating onion config for %s/%s\n", r.instance.Namespace, r.instance.Name)
+		fmt.Println("updating tor config")
 
-		err = ioutil.WriteFile("/run/tor/torfile", []byte(torConfig), 0644)
+		err = ioutil.WriteFile(torConfigPath, []byte(torConfig), 0644)
 		if err != nil {
 			fmt.Printf("Writing config failed with %v\n", err)
 			return err
 		}
 
-		r.reload()
+		if err := r.reload(torConfig); err != nil {
+			fmt.Printf("Reloading config failed with %v\n", err)
+			if err := r.rollbackConfig(); err != nil {
+				fmt.Printf("Rolling back config failed with %v\n", err)
+			}
+			return err
+		}
+	}
+
+	r.lastGoodConfig = torConfig
+	return nil
+}
+
+func (r *TorDaemonReconciler) syncOnionConfig() error {
+	if err := r.hashControlPassword(); err != nil {
+		return err
+	}
+
+	torConfig, err := config.CreateTorConfigForService(r.instance, r.hashedControlPassword)
+	if err != nil {
+		fmt.Printf("Generating config failed with %v\n", err)
+		return err
+	}
+
+	if err := r.applyConfig(torConfig); err != nil {
+		return err
 	}
 
 	err = r.updateOnionServiceStatus()
@@ -106,36 +286,79 @@ func (r *TorDaemonReconciler) syncOnionConfig() error {
 }
 
 func (r *TorDaemonReconciler) updateOnionServiceStatus() error {
-	hostname, err := ioutil.ReadFile("/run/tor/service/hostname")
+	hostname, err := ioutil.ReadFile(path.Join(config.ServiceDir, "hostname"))
 	if err != nil {
 		fmt.Printf("Got this error when trying to find hostname: %v", err)
 		hostname = []byte("")
 	}
 
-	newHostname := strings.TrimSpace(string(hostname))
+	instanceCopy := r.instance.DeepCopy()
+	instanceCopy.Status.Hostname = strings.TrimSpace(string(hostname))
+
+	published, uploaded, failed, complete := r.descriptors.status()
+	if complete {
+		instanceCopy.Status.Descriptor = torv1beta1.DescriptorStatus{
+			HSDirs:       uploaded,
+			FailedHSDirs: failed,
+		}
+		if !published.IsZero() {
+			lastPublished := metav1.NewTime(published)
+			instanceCopy.Status.Descriptor.LastPublished = &lastPublished
+		}
+	}
+
+	if r.instance.Spec.SelfTest != nil {
+		if status, reason, message, ok := r.selfTests.result(); ok {
+			setCondition(&instanceCopy.Status, torv1beta1.OnionServiceReachable, status, reason, message)
+		}
+	} else {
+		removeCondition(&instanceCopy.Status, torv1beta1.OnionServiceReachable)
+	}
 
-	if newHostname != r.instance.Status.Hostname {
-		instanceCopy := r.instance.DeepCopy()
-		instanceCopy.Status.Hostname = newHostname
+	if reflect.DeepEqual(instanceCopy.Status, r.instance.Status) {
+		return nil
+	}
 
-		err = r.Update(r.ctx, instanceCopy)
+	if err := r.Status().Update(r.ctx, instanceCopy); err != nil {
 		return err
 	}
+
+	if complete && !reflect.DeepEqual(instanceCopy.Status.Descriptor, r.instance.Status.Descriptor) {
+		r.recordDescriptorEvent(instanceCopy.Status.Descriptor)
+	}
+
+	r.instance = instanceCopy
 	return nil
 }
 
+func (r *TorDaemonReconciler) recordDescriptorEvent(descriptor torv1beta1.DescriptorStatus) {
+	if descriptor.HSDirs == 0 {
+		r.Recorder.Eventf(r.instance, corev1.EventTypeWarning, DescriptorUploadFailed,
+			"Descriptor upload failed for all %d HSDirs", descriptor.FailedHSDirs)
+		return
+	}
+
+	r.Recorder.Eventf(r.instance, corev1.EventTypeNormal, DescriptorPublished,
+		"Descriptor published at %s to %d HSDirs (%d failed)",
+		descriptor.LastPublished.Format(time.RFC3339), descriptor.HSDirs, descriptor.FailedHSDirs)
+}
+
 func (r *TorDaemonReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	r.ctx = ctx
 	defer cancel()
 
+	if r.PoolName != "" {
+		return r.reconcilePool()
+	}
+
 	// Watch ReplicaSets and enqueue ReplicaSet object key
-	//if err := r.Watch(&source.Kind{Type: &torv1alpha1.OnionService{}}, &handler.EnqueueRequestForObject{}); err != nil {
+	//if err := r.Watch(&source.Kind{Type: &torv1beta1.OnionService{}}, &handler.EnqueueRequestForObject{}); err != nil {
 	//	r.Log.Error(err, "unable to watch OnionServices")
 	//	os.Exit(1)
 	//}
 
-	r.instance = &torv1alpha1.OnionService{}
+	r.instance = &torv1beta1.OnionService{}
 
 	err := r.Get(ctx, types.NamespacedName{Name: r.OnionServiceName, Namespace: r.OnionServiceNamespace}, r.instance)
 	if err != nil {
@@ -165,11 +388,28 @@ func (r *TorDaemonReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
-	//metrics.TorDaemonMetricsExporter.Start()
+	// descriptor uploads are only seen once tor accepts control connections
+	if err := r.connect(); err != nil {
+		r.Log.Info("Control port not available yet", "error", err.Error())
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	if selfTest := r.instance.Spec.SelfTest; selfTest != nil {
+		// only test once the onion can be reached at all
+		if published, _, _, _ := r.descriptors.status(); !published.IsZero() && r.instance.Status.Hostname != "" {
+			r.selfTests.start(r.instance, r.enqueue)
+		}
+		return ctrl.Result{RequeueAfter: selfTestInterval(selfTest)}, nil
+	}
 
-	return ctrl.Result{}, r.syncOnionConfig()
+	return ctrl.Result{}, nil
 }
 
 func (r *TorDaemonReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).For(&torv1alpha1.OnionService{}).Complete(r)
+	r.externalEvents = make(chan event.GenericEvent)
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&torv1beta1.OnionService{}).
+		Watches(&source.Channel{Source: r.externalEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(r)
 }
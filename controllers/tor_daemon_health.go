@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/marcus-sa/tor-operator/pkg/config"
+	"github.com/marcus-sa/tor-operator/pkg/control"
+	"net/http"
+)
+
+// Healthz is a liveness check that fails while the supervised tor process is
+// not running.
+func (r *TorDaemonReconciler) Healthz(_ *http.Request) error {
+	if !r.running() {
+		return fmt.Errorf("tor is not running")
+	}
+
+	return nil
+}
+
+// Readyz is a readiness check that passes once tor finished bootstrapping and
+// the descriptor of the hidden service was accepted by an HSDir.
+func (r *TorDaemonReconciler) Readyz(_ *http.Request) error {
+	if err := r.Healthz(nil); err != nil {
+		return err
+	}
+
+	// a dedicated connection keeps probes independent of the reconciler
+	conn, err := control.Dial(config.ControlSocket, "", r.ControlPassword)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	progress, err := conn.BootstrapProgress()
+	if err != nil {
+		return err
+	}
+	if progress < 100 {
+		return fmt.Errorf("tor is bootstrapping (%d%%)", progress)
+	}
+
+	if published, _, _, _ := r.descriptors.status(); published.IsZero() {
+		return fmt.Errorf("descriptor has not been published yet")
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/config"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"path"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+	"time"
+)
+
+// poolMembers returns the OnionServices served by the pool, sorted by name to
+// keep the torfile stable.
+func (r *TorDaemonReconciler) poolMembers() ([]torv1beta1.OnionService, error) {
+	list := &torv1beta1.OnionServiceList{}
+	if err := r.List(r.ctx, list, client.InNamespace(r.OnionServiceNamespace)); err != nil {
+		return nil, err
+	}
+
+	var members []torv1beta1.OnionService
+	for _, onion := range list.Items {
+		if onion.Spec.Pool == r.PoolName && onion.DeletionTimestamp == nil && servedByPool(&onion) {
+			members = append(members, onion)
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+	return members, nil
+}
+
+// servedByPool reports whether the TorDaemonPool accepted onion in its
+// Pooled condition. Members it skipped have an invalid spec, which would fail
+// the torfile of all members, or no key mounted, so tor would publish a
+// random address for them.
+func servedByPool(onion *torv1beta1.OnionService) bool {
+	condition := findCondition(&onion.Status, torv1beta1.OnionServicePooled)
+	return condition != nil && condition.Status == corev1.ConditionTrue
+}
+
+// reconcilePool renders the hidden services of all members of the pool into
+// one torfile and publishes their hostnames. Descriptor status and self-tests
+// are only tracked for daemons hosting a single OnionService.
+func (r *TorDaemonReconciler) reconcilePool() (ctrl.Result, error) {
+	members, err := r.poolMembers()
+	if err != nil {
+		r.Log.Error(err, "Could not list members of TorDaemonPool")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.hashControlPassword(); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	torConfig, err := config.CreateTorConfigForPool(members, r.hashedControlPassword)
+	if err != nil {
+		fmt.Printf("Generating config failed with %v\n", err)
+		return ctrl.Result{}, err
+	}
+
+	if err := r.applyConfig(torConfig); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var errs []error
+	for i := range members {
+		if err := r.updatePoolMemberStatus(&members[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := kerrors.NewAggregate(errs); err != nil {
+		fmt.Printf("Updating status failed with %v\n", err)
+		return ctrl.Result{}, err
+	}
+
+	// HS_DESC events queue a reconcile to pick up the hostnames of new members
+	if err := r.connect(); err != nil {
+		r.Log.Info("Control port not available yet", "error", err.Error())
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	return ctrl.Result{}, nil
+}
+
+func (r *TorDaemonReconciler) updatePoolMemberStatus(onion *torv1beta1.OnionService) error {
+	hostname, err := ioutil.ReadFile(path.Join(config.PoolServiceDir(onion), "hostname"))
+	if err != nil {
+		hostname = []byte("")
+	}
+
+	instanceCopy := onion.DeepCopy()
+	instanceCopy.Status.Hostname = strings.TrimSpace(string(hostname))
+
+	if instanceCopy.Status.Hostname == onion.Status.Hostname {
+		return nil
+	}
+
+	return r.Status().Update(r.ctx, instanceCopy)
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	torv1alpha1 "github.com/marcus-sa/tor-operator/api/v1alpha1"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func poolMember(name string, version int) *torv1beta1.OnionService {
+	return &torv1beta1.OnionService{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: torv1beta1.OnionServiceSpec{
+			Version: version,
+			Pool:    "frontends",
+			Backend: torv1beta1.BackendSpec{
+				Selector: map[string]string{"app": name},
+				Ports: []torv1beta1.ServicePort{
+					{Name: "http", PublicPort: 80, TargetPort: intstr.FromInt(8080)},
+				},
+			},
+		},
+	}
+}
+
+func TestPoolSkipsInvalidMember(t *testing.T) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme, torv1alpha1.AddToScheme, torv1beta1.AddToScheme,
+	} {
+		if err := add(scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool := &torv1alpha1.TorDaemonPool{ObjectMeta: metav1.ObjectMeta{Name: "frontends", Namespace: "default"}}
+	// version 2 onions without a migration fail the torfile
+	invalid := poolMember("a-invalid", 2)
+	healthy := poolMember("b-healthy", 3)
+	c := fake.NewFakeClientWithScheme(scheme, pool, invalid, healthy)
+
+	poolReconciler := &TorDaemonPoolReconciler{
+		Client:   c,
+		Log:      ctrl.Log.WithName("test"),
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+	if _, err := poolReconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: "frontends", Namespace: "default"}}); err != nil {
+		t.Fatalf("TorDaemonPoolReconciler.Reconcile() error = %v", err)
+	}
+
+	ctx := context.Background()
+	for name, want := range map[string]corev1.ConditionStatus{
+		"a-invalid": corev1.ConditionFalse,
+		"b-healthy": corev1.ConditionTrue,
+	} {
+		onion := &torv1beta1.OnionService{}
+		if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, onion); err != nil {
+			t.Fatal(err)
+		}
+		condition := findCondition(&onion.Status, torv1beta1.OnionServicePooled)
+		if condition == nil || condition.Status != want {
+			t.Errorf("%s: Pooled condition = %+v, want %s", name, condition, want)
+		}
+	}
+
+	daemonReconciler := &TorDaemonReconciler{
+		Client:                c,
+		OnionServiceNamespace: "default",
+		PoolName:              "frontends",
+		ctx:                   ctx,
+	}
+	members, err := daemonReconciler.poolMembers()
+	if err != nil {
+		t.Fatalf("poolMembers() error = %v", err)
+	}
+	if len(members) != 1 || members[0].Name != "b-healthy" {
+		t.Fatalf("poolMembers() = %v, want only b-healthy", members)
+	}
+
+	torConfig, err := config.CreateTorConfigForPool(members, "")
+	if err != nil {
+		t.Fatalf("CreateTorConfigForPool() error = %v", err)
+	}
+	if !strings.Contains(torConfig, "HiddenServiceDir "+config.PoolServiceDir(healthy)+"\n") ||
+		strings.Contains(torConfig, config.PoolServiceDir(invalid)) {
+		t.Errorf("CreateTorConfigForPool() =\n%s\nwant only the hidden service of b-healthy", torConfig)
+	}
+}
@@ -0,0 +1,440 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-logr/logr"
+	torv1alpha1 "github.com/marcus-sa/tor-operator/api/v1alpha1"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/config"
+	"github.com/marcus-sa/tor-operator/pkg/metrics"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sort"
+)
+
+const (
+	// MessagePooled is the message of the Pooled condition of OnionServices
+	// served by their pool
+	MessagePooled = "Served by TorDaemonPool %s"
+)
+
+// TorDaemonPoolReconciler reconciles a TorDaemonPool object
+type TorDaemonPoolReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	// recorder is an event recorder for recording Event resources to the
+	// Kubernetes API.
+	Recorder record.EventRecorder
+
+	ctx      context.Context
+	instance *torv1alpha1.TorDaemonPool
+	members  []torv1beta1.OnionService
+	// privateKeys holds the validated private key of each member
+	privateKeys []*privateKey
+}
+
+func (r *TorDaemonPoolReconciler) NewObjectMeta() *metav1.ObjectMeta {
+	return &metav1.ObjectMeta{
+		Name:      r.instance.Name,
+		Namespace: r.instance.Namespace,
+		OwnerReferences: []metav1.OwnerReference{
+			*r.NewOwnerReference(),
+		},
+	}
+}
+
+func (r *TorDaemonPoolReconciler) NewOwnerReference() *metav1.OwnerReference {
+	return metav1.NewControllerRef(r.instance, schema.GroupVersionKind{
+		Group:   torv1alpha1.GroupVersion.Group,
+		Version: torv1alpha1.GroupVersion.Version,
+		Kind:    "TorDaemonPool",
+	})
+}
+
+// +kubebuilder:rbac:groups=tor.k8s.io,resources=tordaemonpools,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=tor.k8s.io,resources=tordaemonpools/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=tor.k8s.io,resources=onionservices/status,verbs=get;update;patch
+func (r *TorDaemonPoolReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	r.ctx = context.Background()
+	log := r.Log.WithValues(req.Name, req.NamespacedName)
+
+	r.instance = &torv1alpha1.TorDaemonPool{}
+
+	if err := r.Get(r.ctx, req.NamespacedName, r.instance); err != nil {
+		log.Error(err, "unable to fetch TorDaemonPool")
+
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	if err := r.listMembers(); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// members that cannot be served are skipped and report why in their
+	// Pooled condition, the others keep being served. Members are checked
+	// in the order of their names, so the first one sets the options of the
+	// tor process.
+	var served []torv1beta1.OnionService
+	r.privateKeys = nil
+	for i := range r.members {
+		onion := &r.members[i]
+		pk, reason, msg, err := r.checkMember(onion, served)
+		if err != nil {
+			metrics.ReconcileErrors.WithLabelValues("Secret").Inc()
+			return ctrl.Result{}, err
+		}
+
+		if reason != "" {
+			eventType := corev1.EventTypeWarning
+			if reason == VanityPending {
+				eventType = corev1.EventTypeNormal
+			}
+			r.Recorder.Event(r.instance, eventType, reason, fmt.Sprintf("OnionService %s: %s", onion.Name, msg))
+		} else {
+			served = append(served, *onion)
+			r.privateKeys = append(r.privateKeys, pk)
+		}
+
+		if err := r.setPooledCondition(onion, reason, msg); err != nil {
+			metrics.ReconcileErrors.WithLabelValues("OnionService").Inc()
+			return ctrl.Result{}, err
+		}
+	}
+	r.members = served
+
+	if err := r.reconcileServiceAccount(); err != nil {
+		metrics.ReconcileErrors.WithLabelValues("ServiceAccount").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileRole(); err != nil {
+		metrics.ReconcileErrors.WithLabelValues("Role").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileRoleBinding(); err != nil {
+		metrics.ReconcileErrors.WithLabelValues("RoleBinding").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileNetworkPolicy(); err != nil {
+		metrics.ReconcileErrors.WithLabelValues("NetworkPolicy").Inc()
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileDeployment(); err != nil {
+		metrics.ReconcileErrors.WithLabelValues("Deployment").Inc()
+		return ctrl.Result{}, err
+	}
+
+	var members []string
+	for _, onion := range r.members {
+		members = append(members, onion.Name)
+	}
+
+	if !reflect.DeepEqual(members, r.instance.Status.Members) {
+		instanceCopy := r.instance.DeepCopy()
+		instanceCopy.Status.Members = members
+		if err := r.Status().Update(r.ctx, instanceCopy); err != nil {
+			metrics.ReconcileErrors.WithLabelValues("TorDaemonPool").Inc()
+			return ctrl.Result{}, err
+		}
+	}
+
+	r.Recorder.Event(r.instance, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+	return ctrl.Result{}, nil
+}
+
+// listMembers collects the OnionServices hosted by the pool, sorted by name.
+func (r *TorDaemonPoolReconciler) listMembers() error {
+	list := &torv1beta1.OnionServiceList{}
+	if err := r.List(r.ctx, list, client.InNamespace(r.instance.Namespace)); err != nil {
+		return err
+	}
+
+	r.members = nil
+	for _, onion := range list.Items {
+		if onion.Spec.Pool == r.instance.Name && onion.DeletionTimestamp == nil {
+			r.members = append(r.members, onion)
+		}
+	}
+
+	sort.Slice(r.members, func(i, j int) bool {
+		return r.members[i].Name < r.members[j].Name
+	})
+	return nil
+}
+
+// checkMember returns the private key of onion, or the reason and message
+// why the pool cannot serve it next to the members served. The daemon would
+// refuse to render an invalid torrc, and a member without a usable key would
+// publish a different address.
+func (r *TorDaemonPoolReconciler) checkMember(onion *torv1beta1.OnionService, served []torv1beta1.OnionService) (*privateKey, string, string, error) {
+	members := append(append([]torv1beta1.OnionService{}, served...), *onion)
+	if _, err := config.CreateTorConfigForPool(members, ""); err != nil {
+		return nil, ErrInvalidSpec, err.Error(), nil
+	}
+	if err := validateOnionService(onion); err != nil {
+		return nil, ErrInvalidSpec, err.Error(), nil
+	}
+
+	if vanityPending(onion) {
+		return nil, VanityPending, fmt.Sprintf(MessageVanityPending, onion.Name), nil
+	}
+
+	pk, err := readPrivateKey(r.ctx, r, onion)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if pk != nil && pk.invalid != nil {
+		return nil, KeyInvalid, fmt.Sprintf(MessageKeyInvalid, pk.secret.Name, pk.invalid), nil
+	}
+
+	return pk, "", "", nil
+}
+
+// setPooledCondition records in the Pooled condition of onion whether the
+// pool serves it, reason is empty if it does.
+func (r *TorDaemonPoolReconciler) setPooledCondition(onion *torv1beta1.OnionService, reason, message string) error {
+	status := onion.Status.DeepCopy()
+	if reason == "" {
+		setCondition(status, torv1beta1.OnionServicePooled, corev1.ConditionTrue, "Served",
+			fmt.Sprintf(MessagePooled, r.instance.Name))
+	} else {
+		setCondition(status, torv1beta1.OnionServicePooled, corev1.ConditionFalse, reason, message)
+	}
+
+	if reflect.DeepEqual(status, &onion.Status) {
+		return nil
+	}
+
+	onion.Status = *status
+	return r.Status().Update(r.ctx, onion)
+}
+
+// poolPodLabels returns the labels of the daemon of the TorDaemonPool name.
+func poolPodLabels(name string) map[string]string {
+	return map[string]string{
+		"app":  "tor",
+		"api":  "tor",
+		"pool": name,
+	}
+}
+
+func (r *TorDaemonPoolReconciler) torDeployment() (*appsv1.Deployment, error) {
+	labels := poolPodLabels(r.instance.Name)
+
+	var volumes []corev1.Volume
+	var volumeMounts []corev1.VolumeMount
+	var initContainers []corev1.Container
+	for i := range r.members {
+		onion := &r.members[i]
+		// volume names are limited to 63 characters, unlike OnionServices
+		name := fmt.Sprintf("%s-%d", privateKeyVolume, i)
+		v, m, c := privateKeyVolumes(onion, r.privateKeys[i], name, config.PoolServiceDir(onion))
+		volumes = append(volumes, v...)
+		volumeMounts = append(volumeMounts, m...)
+		initContainers = append(initContainers, c...)
+	}
+
+	args := []string{
+		"--pool",
+		r.instance.Name,
+		"--namespace",
+		r.instance.Namespace,
+	}
+	if r.instance.Spec.ControlPassword {
+		args = append(args, "--control-password")
+	}
+
+	hash, err := privateKeyHash(r.ctx, r, r.instance.Namespace, volumes, volumeMounts)
+	if err != nil {
+		return nil, err
+	}
+
+	// like single daemons, a pool must not run twice with the same keys
+	deployment := &appsv1.Deployment{
+		ObjectMeta: *r.NewObjectMeta(),
+		Spec:       torDeploymentSpec(labels, r.instance.Name, appsv1.RecreateDeploymentStrategyType, args, volumes, volumeMounts, initContainers),
+	}
+	// all members share the mode of the daemon
+	var mode torv1beta1.OnionServiceMode
+	if len(r.members) > 0 {
+		mode = r.members[0].Spec.Mode
+	}
+	deployment.Spec.Template.Annotations = podTemplateAnnotations(hash, mode)
+
+	err = controllerutil.SetControllerReference(r.instance, deployment, r.Scheme)
+	return deployment, err
+}
+
+func (r *TorDaemonPoolReconciler) reconcileDeployment() error {
+	deployment, err := r.torDeployment()
+	if err != nil {
+		return err
+	}
+	found := &appsv1.Deployment{}
+
+	if err := r.Get(r.ctx, types.NamespacedName{Name: deployment.Name, Namespace: deployment.Namespace}, found); err != nil {
+		if errors.IsNotFound(err) {
+			return r.Create(r.ctx, deployment)
+		}
+		return err
+	}
+
+	if !reflect.DeepEqual(deployment.Spec, found.Spec) {
+		found.Spec = deployment.Spec
+		r.Log.Info("Updating Deployment %s/%s\n", deployment.Namespace, deployment.Name)
+		return r.Update(r.ctx, found)
+	}
+
+	return nil
+}
+
+func (r *TorDaemonPoolReconciler) reconcileServiceAccount() error {
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: *r.NewObjectMeta(),
+	}
+	found := &corev1.ServiceAccount{}
+
+	err := r.Get(r.ctx, types.NamespacedName{Name: serviceAccount.Name, Namespace: serviceAccount.Namespace}, found)
+	if errors.IsNotFound(err) {
+		return r.Create(r.ctx, serviceAccount)
+	}
+
+	return err
+}
+
+func (r *TorDaemonPoolReconciler) reconcileRole() error {
+	role := &rbacv1.Role{
+		ObjectMeta: *r.NewObjectMeta(),
+		Rules:      torDaemonRules(),
+	}
+	found := &rbacv1.Role{}
+
+	err := r.Get(r.ctx, types.NamespacedName{Name: role.Name, Namespace: role.Namespace}, found)
+	if errors.IsNotFound(err) {
+		return r.Create(r.ctx, role)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(role.Rules, found.Rules) {
+		found.Rules = role.Rules
+		r.Log.Info("Updating Role %s/%s\n", role.Namespace, role.Name)
+		return r.Update(r.ctx, found)
+	}
+
+	return nil
+}
+
+func (r *TorDaemonPoolReconciler) reconcileRoleBinding() error {
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: *r.NewObjectMeta(),
+		Subjects: []rbacv1.Subject{
+			{
+				Kind: rbacv1.ServiceAccountKind,
+				Name: r.instance.Name,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind: "Role",
+			Name: r.instance.Name,
+		},
+	}
+	found := &rbacv1.RoleBinding{}
+
+	err := r.Get(r.ctx, types.NamespacedName{Name: roleBinding.Name, Namespace: roleBinding.Namespace}, found)
+	if errors.IsNotFound(err) {
+		return r.Create(r.ctx, roleBinding)
+	}
+
+	return err
+}
+
+// poolsOfOnion maps an OnionService to the TorDaemonPool hosting it and to
+// the pools still listing it as a member, so a pool it left drops it.
+func (r *TorDaemonPoolReconciler) poolsOfOnion(obj handler.MapObject) []reconcile.Request {
+	onion, ok := obj.Object.(*torv1beta1.OnionService)
+	if !ok {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	if onion.Spec.Pool != "" {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: onion.Spec.Pool, Namespace: onion.Namespace},
+		})
+	}
+
+	pools := &torv1alpha1.TorDaemonPoolList{}
+	if err := r.List(context.Background(), pools, client.InNamespace(onion.Namespace)); err != nil {
+		r.Log.Error(err, "unable to list TorDaemonPools")
+		return requests
+	}
+	for _, pool := range pools.Items {
+		if pool.Name == onion.Spec.Pool {
+			continue
+		}
+		for _, member := range pool.Status.Members {
+			if member == onion.Name {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: pool.Name, Namespace: pool.Namespace},
+				})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+func (r *TorDaemonPoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&torv1alpha1.TorDaemonPool{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Watches(&source.Kind{Type: &torv1beta1.OnionService{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.poolsOfOnion)}).
+		Watches(&source.Kind{Type: &corev1.Secret{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.poolsOfSecret)}).
+		Complete(r)
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"net"
+	"strings"
+)
+
+const (
+	// proxyProtocolPortName is the name, or prefix followed by a dash, of
+	// backend ports confirmed to accept the PROXY protocol
+	proxyProtocolPortName = "proxy"
+)
+
+// validateOnionService checks the parts of the spec of onion which neither the
+// API server nor rendering the torrc can validate.
+func validateOnionService(onion *torv1beta1.OnionService) error {
+	if options := onion.Spec.TorOptions; options != nil && options.ExportCircuitID == torv1beta1.ExportCircuitIDHAProxy {
+		// tor cannot tell whether a backend understands the header, so the
+		// ports have to be named to confirm it
+		for _, p := range onion.Spec.Backend.Ports {
+			if p.Name != proxyProtocolPortName && !strings.HasPrefix(p.Name, proxyProtocolPortName+"-") {
+				return fmt.Errorf("exportCircuitID %s sends a PROXY protocol header to every port, "+
+					"name port %d %q or %q-<name> once its backend accepts it",
+					torv1beta1.ExportCircuitIDHAProxy, p.PublicPort, proxyProtocolPortName, proxyProtocolPortName)
+			}
+		}
+	}
+
+	if provider := onion.Spec.KeyProvider; provider != nil {
+		if provider.Vault == nil {
+			return fmt.Errorf("keyProvider requires vault")
+		}
+		if onion.Spec.PrivateKeySecret != nil || onion.Spec.KeyRotation != nil || onion.Spec.VanityPrefix != "" {
+			return fmt.Errorf("keyProvider cannot be combined with privateKeySecret, keyRotation or vanityPrefix")
+		}
+	}
+
+	if policy := onion.Spec.NetworkPolicy; policy != nil {
+		// the private ranges are excepted from 0.0.0.0/0
+		for _, cidr := range policy.PrivateCIDRs {
+			if ip, _, err := net.ParseCIDR(cidr); err != nil || ip.To4() == nil {
+				return fmt.Errorf("networkPolicy.privateCIDRs: %q is not an IPv4 range", cidr)
+			}
+		}
+		for _, cidr := range policy.APIServerCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("networkPolicy.apiServerCIDRs: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"testing"
+
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestValidateOnionService(t *testing.T) {
+	haproxy := &torv1beta1.TorOptions{ExportCircuitID: torv1beta1.ExportCircuitIDHAProxy}
+	vault := &torv1beta1.VaultKeyProvider{Address: "https://vault:8200", Path: "onions/example", Role: "tor"}
+
+	tests := []struct {
+		name    string
+		spec    torv1beta1.OnionServiceSpec
+		wantErr string
+	}{
+		{
+			name: "valid",
+			spec: torv1beta1.OnionServiceSpec{Version: 3},
+		},
+		{
+			name: "exportCircuitID with proxy ports",
+			spec: torv1beta1.OnionServiceSpec{
+				Version:    3,
+				TorOptions: haproxy,
+				Backend: torv1beta1.BackendSpec{Ports: []torv1beta1.ServicePort{
+					{Name: "proxy", PublicPort: 80, TargetPort: intstr.FromInt(8080)},
+					{Name: "proxy-https", PublicPort: 443, TargetPort: intstr.FromInt(8443)},
+				}},
+			},
+		},
+		{
+			name: "exportCircuitID with an unnamed port",
+			spec: torv1beta1.OnionServiceSpec{
+				Version:    3,
+				TorOptions: haproxy,
+				Backend: torv1beta1.BackendSpec{Ports: []torv1beta1.ServicePort{
+					{Name: "proxy", PublicPort: 80, TargetPort: intstr.FromInt(8080)},
+					{Name: "proxyhttps", PublicPort: 443, TargetPort: intstr.FromInt(8443)},
+				}},
+			},
+			wantErr: `exportCircuitID haproxy sends a PROXY protocol header to every port, ` +
+				`name port 443 "proxy" or "proxy"-<name> once its backend accepts it`,
+		},
+		{
+			name: "keyProvider without vault",
+			spec: torv1beta1.OnionServiceSpec{
+				Version:     3,
+				KeyProvider: &torv1beta1.KeyProviderSpec{},
+			},
+			wantErr: "keyProvider requires vault",
+		},
+		{
+			name: "keyProvider with privateKeySecret",
+			spec: torv1beta1.OnionServiceSpec{
+				Version:          3,
+				KeyProvider:      &torv1beta1.KeyProviderSpec{Vault: vault},
+				PrivateKeySecret: &torv1beta1.SecretReference{Name: "example-key", Key: "hs_ed25519_secret_key"},
+			},
+			wantErr: "keyProvider cannot be combined with privateKeySecret, keyRotation or vanityPrefix",
+		},
+		{
+			name: "networkPolicy",
+			spec: torv1beta1.OnionServiceSpec{
+				Version: 3,
+				NetworkPolicy: &torv1beta1.NetworkPolicySpec{
+					PrivateCIDRs:   []string{"10.0.0.0/8"},
+					APIServerCIDRs: []string{"10.0.0.1/32", "fd00::1/128"},
+				},
+			},
+		},
+		{
+			name: "networkPolicy with an IPv6 private range",
+			spec: torv1beta1.OnionServiceSpec{
+				Version:       3,
+				NetworkPolicy: &torv1beta1.NetworkPolicySpec{PrivateCIDRs: []string{"fd00::/8"}},
+			},
+			wantErr: `networkPolicy.privateCIDRs: "fd00::/8" is not an IPv4 range`,
+		},
+		{
+			name: "networkPolicy with an invalid API server range",
+			spec: torv1beta1.OnionServiceSpec{
+				Version:       3,
+				NetworkPolicy: &torv1beta1.NetworkPolicySpec{APIServerCIDRs: []string{"10.0.0.1"}},
+			},
+			wantErr: "networkPolicy.apiServerCIDRs: invalid CIDR address: 10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOnionService(&torv1beta1.OnionService{Spec: tt.spec})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateOnionService() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("validateOnionService() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/keys"
+	"github.com/marcus-sa/tor-operator/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// VanityKeyFound is used as part of the Event 'reason' when a key
+	// matching the vanity prefix of an OnionService is found
+	VanityKeyFound = "VanityKeyFound"
+	// MessageVanityKeyFound is the message used for Events fired when a
+	// vanity key is found
+	MessageVanityKeyFound = "Found key for %s after %d attempts"
+	// VanitySearchFailed is used as part of the Event 'reason' when the
+	// search for a vanity key gives up
+	VanitySearchFailed = "VanitySearchFailed"
+	// MessageVanitySearchFailed is the message used for Events and the
+	// status of failed vanity key searches
+	MessageVanitySearchFailed = "No key for prefix %q found after %d attempts: %v"
+	// VanityPending is used as part of the Event 'reason' when a
+	// TorDaemonPool is not rolled out until the vanity key of a member is
+	// found
+	VanityPending = "VanityPending"
+	// MessageVanityPending is the message used for Events of pools waiting
+	// for a vanity key
+	MessageVanityPending = "OnionService %s waits for its vanity key"
+
+	// vanitySearchTimeout bounds the time spent on the search for one key
+	vanitySearchTimeout = 12 * time.Hour
+	// vanityProgressInterval is how often the progress of a search is
+	// written to the status
+	vanityProgressInterval = 30 * time.Second
+)
+
+// vanitySearch is a search for the key of an OnionService running in the
+// background.
+type vanitySearch struct {
+	prefix   string
+	start    time.Time
+	cancel   context.CancelFunc
+	attempts uint64
+	// done is closed once key or err is set
+	done chan struct{}
+	key  *keys.Key
+	err  error
+}
+
+// startVanitySearch starts the search for a key matching prefix with the
+// workers of limiter. A search interrupted by a restart of the operator is
+// resumed from its status, keeping its start time and attempts.
+func startVanitySearch(prefix string, limiter keys.VanityLimiter, resumed *torv1beta1.VanityStatus) *vanitySearch {
+	search := &vanitySearch{
+		prefix: prefix,
+		start:  time.Now(),
+		done:   make(chan struct{}),
+	}
+	if resumed != nil && resumed.Prefix == prefix && resumed.Phase == torv1beta1.VanitySearching && resumed.StartTime != nil {
+		search.start = resumed.StartTime.Time
+		search.attempts = uint64(resumed.Attempts)
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), search.start.Add(vanitySearchTimeout))
+	search.cancel = cancel
+
+	go func() {
+		defer close(search.done)
+		defer cancel()
+		search.key, search.err = keys.SearchVanity(ctx, prefix, limiter, &search.attempts)
+	}()
+
+	return search
+}
+
+// status reports the progress of the search.
+func (s *vanitySearch) status() *torv1beta1.VanityStatus {
+	attempts := atomic.LoadUint64(&s.attempts)
+	expected := keys.VanityAttempts(s.prefix)
+	start := metav1.NewTime(s.start)
+
+	status := &torv1beta1.VanityStatus{
+		Prefix:           s.prefix,
+		Phase:            torv1beta1.VanitySearching,
+		Attempts:         int64(attempts),
+		ExpectedAttempts: int64(expected),
+		StartTime:        &start,
+	}
+
+	// every key matches with the same chance, so the expected remaining
+	// time does not shrink with the attempts made so far
+	if elapsed := time.Since(s.start); attempts > 0 && elapsed > 0 {
+		rate := float64(attempts) / elapsed.Seconds()
+		eta := metav1.NewTime(time.Now().Add(time.Duration(float64(expected) / rate * float64(time.Second))))
+		status.EstimatedCompletionTime = &eta
+	}
+
+	return status
+}
+
+// vanityKeySecret references the Secret holding the key of onion matching
+// prefix. Every prefix gets a Secret of its own, so the key in use is kept
+// while the key of a new prefix is searched for.
+func vanityKeySecret(onion *torv1beta1.OnionService, prefix string) *torv1beta1.SecretReference {
+	return &torv1beta1.SecretReference{
+		Name: onion.Name + "-vanity-key-" + prefix,
+		Key:  keys.SecretKeyFileName,
+	}
+}
+
+// vanityPending returns whether onion waits for its first vanity key. After a
+// change of the prefix the key of the previous prefix is served until the
+// new one is found.
+func vanityPending(onion *torv1beta1.OnionService) bool {
+	if onion.Spec.VanityPrefix == "" {
+		return false
+	}
+	vanity := onion.Status.Vanity
+	return vanity == nil || vanity.KeySecret == nil
+}
+
+// ReconcileVanity runs the search for a key matching the vanity prefix of the
+// instance in the background and stores the key in a Secret once found. The
+// progress is recorded in the status of the instance, which is written by
+// UpdateServiceStatus.
+func (r *OnionServiceReconciler) ReconcileVanity() error {
+	name := types.NamespacedName{Name: r.instance.Name, Namespace: r.instance.Namespace}
+	prefix := r.instance.Spec.VanityPrefix
+	status := &r.instance.Status
+
+	search := r.vanitySearches[name]
+	if search != nil && search.prefix != prefix {
+		r.cancelVanitySearch(name)
+		search = nil
+	}
+
+	if prefix == "" {
+		status.Vanity = nil
+		return nil
+	}
+
+	// the key in use until a key matching the prefix is found
+	var inUse *torv1beta1.SecretReference
+	if status.Vanity != nil {
+		inUse = status.Vanity.KeySecret
+	}
+
+	// found keys are kept and failed searches are only retried with a new
+	// prefix
+	if vanity := status.Vanity; vanity != nil && vanity.Prefix == prefix && vanity.Phase != torv1beta1.VanitySearching {
+		return nil
+	}
+
+	ref := vanityKeySecret(r.instance, prefix)
+	secret := &corev1.Secret{}
+	err := r.Get(r.ctx, types.NamespacedName{Name: ref.Name, Namespace: r.instance.Namespace}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	// the key was stored but the status was not updated
+	if err == nil && strings.HasPrefix(string(secret.Data[generatedHostnameKey]), prefix) {
+		r.cancelVanitySearch(name)
+		status.Vanity = &torv1beta1.VanityStatus{
+			Prefix:           prefix,
+			Phase:            torv1beta1.VanityFound,
+			ExpectedAttempts: int64(keys.VanityAttempts(prefix)),
+			KeySecret:        ref,
+		}
+		return nil
+	}
+
+	if search == nil {
+		if r.vanitySearches == nil {
+			r.vanitySearches = map[types.NamespacedName]*vanitySearch{}
+		}
+		if r.vanityLimiter == nil {
+			r.vanityLimiter = keys.NewVanityLimiter(r.VanityWorkers)
+		}
+		search = startVanitySearch(prefix, r.vanityLimiter, status.Vanity)
+		r.vanitySearches[name] = search
+	}
+
+	select {
+	case <-search.done:
+	default:
+		status.Vanity = search.status()
+		status.Vanity.KeySecret = inUse
+		return nil
+	}
+
+	delete(r.vanitySearches, name)
+	vanity := search.status()
+	vanity.EstimatedCompletionTime = nil
+
+	if search.err != nil {
+		vanity.KeySecret = inUse
+		vanity.Phase = torv1beta1.VanityFailed
+		vanity.Message = fmt.Sprintf(MessageVanitySearchFailed, prefix, vanity.Attempts, search.err)
+		r.Recorder.Event(r.instance, corev1.EventTypeWarning, VanitySearchFailed, vanity.Message)
+		status.Vanity = vanity
+		return nil
+	}
+
+	if err := r.storeVanityKey(ref, search.key); err != nil {
+		// keep the key around for the next reconcile
+		r.vanitySearches[name] = search
+		return err
+	}
+
+	vanity.Phase = torv1beta1.VanityFound
+	vanity.KeySecret = ref
+	status.Vanity = vanity
+	r.Recorder.Event(r.instance, corev1.EventTypeNormal, VanityKeyFound,
+		fmt.Sprintf(MessageVanityKeyFound, search.key.Hostname(), vanity.Attempts))
+	return nil
+}
+
+// storeVanityKey writes key into a new Secret referenced by ref. An existing
+// Secret is never replaced, it may hold the address in use.
+func (r *OnionServiceReconciler) storeVanityKey(ref *torv1beta1.SecretReference, key *keys.Key) error {
+	// not owned by the OnionService, losing the key means losing the
+	// address
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ref.Name,
+			Namespace: r.instance.Namespace,
+		},
+		Data: map[string][]byte{
+			ref.Key:              key.SecretKeyFile(),
+			generatedHostnameKey: []byte(key.Hostname()),
+		},
+	}
+	r.Log.Info("Creating Secret %s/%s\n", secret.Namespace, secret.Name)
+	if err := r.Create(r.ctx, secret); err != nil {
+		return err
+	}
+	metrics.KeysGenerated.WithLabelValues("3", metrics.KeySourceVanity).Inc()
+	return nil
+}
+
+// cancelVanitySearch stops the search for the key of the OnionService name.
+func (r *OnionServiceReconciler) cancelVanitySearch(name types.NamespacedName) {
+	if search, ok := r.vanitySearches[name]; ok {
+		search.cancel()
+		delete(r.vanitySearches, name)
+	}
+}
+
+// vanityRequeue returns when to report the progress of a running search, or
+// 0 if no search is running.
+func (r *OnionServiceReconciler) vanityRequeue() time.Duration {
+	vanity := r.instance.Status.Vanity
+	if vanity == nil || vanity.Phase != torv1beta1.VanitySearching {
+		return 0
+	}
+	return vanityProgressInterval
+}
@@ -0,0 +1,135 @@
+// Package backup exports the keys of OnionServices into bundles encrypted
+// with OpenPGP and stores them in a directory or an S3 compatible object
+// store.
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	// keys without hash preferences fall back to RIPEMD160
+	_ "golang.org/x/crypto/ripemd160"
+)
+
+// BundleVersion is the version of the bundle format written by Encrypt.
+const BundleVersion = 1
+
+// messageType is the armor type of encrypted bundles.
+const messageType = "PGP MESSAGE"
+
+// Bundle holds the key material of the OnionServices of a namespace.
+type Bundle struct {
+	Version       int            `json:"version"`
+	Created       time.Time      `json:"created"`
+	Namespace     string         `json:"namespace"`
+	OnionServices []OnionService `json:"onionServices"`
+}
+
+// OnionService is the key material of one OnionService.
+type OnionService struct {
+	Name     string `json:"name"`
+	Hostname string `json:"hostname,omitempty"`
+	// KeySecret is the key the OnionService is bound to on restore.
+	KeySecret torv1beta1.SecretReference `json:"keySecret"`
+	// Secrets are all Secrets holding keys of the OnionService, including
+	// the ones of migrations and rotations.
+	Secrets []Secret `json:"secrets"`
+}
+
+// Secret is the content of a Secret holding key material.
+type Secret struct {
+	Name string            `json:"name"`
+	Data map[string][]byte `json:"data"`
+}
+
+// ReadKeyRing reads the armored OpenPGP keys in data.
+func ReadKeyRing(data []byte) (openpgp.EntityList, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid armored OpenPGP key: %v", err)
+	}
+	return keyring, nil
+}
+
+// Encrypt serializes bundle and encrypts it to recipients. The result is
+// ASCII armored.
+func Encrypt(bundle *Bundle, recipients openpgp.EntityList) ([]byte, error) {
+	plaintext, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	armored, err := armor.Encode(&buf, messageType, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := openpgp.Encrypt(armored, recipients, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := armored.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decrypt decrypts a bundle written by Encrypt with one of the private keys
+// in keyring. Keys protected by a passphrase are unlocked with passphrase.
+func Decrypt(data []byte, keyring openpgp.EntityList, passphrase []byte) (*Bundle, error) {
+	block, err := armor.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("bundle is not armored: %v", err)
+	}
+	if block.Type != messageType {
+		return nil, fmt.Errorf("bundle is a %s instead of a %s", block.Type, messageType)
+	}
+
+	prompted := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if prompted || symmetric || len(passphrase) == 0 {
+			return nil, fmt.Errorf("no passphrase to unlock the private key")
+		}
+		prompted = true
+		for _, k := range keys {
+			if k.PrivateKey != nil && k.PrivateKey.Encrypted {
+				if err := k.PrivateKey.Decrypt(passphrase); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, nil
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, keyring, prompt, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt bundle: %v", err)
+	}
+	plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt bundle: %v", err)
+	}
+
+	bundle := &Bundle{}
+	if err := json.Unmarshal(plaintext, bundle); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %v", err)
+	}
+	if bundle.Version != BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+	return bundle, nil
+}
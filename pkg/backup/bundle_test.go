@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"golang.org/x/crypto/openpgp"
+)
+
+// testdata/recipient.asc is a key generated by GnuPG and protected with the
+// passphrase testPassphrase, testdata/bundle.pgp a bundle gpg encrypted to it.
+const testPassphrase = "backup"
+
+func readTestKeyRing(t *testing.T, name string) openpgp.EntityList {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := ReadKeyRing(data)
+	if err != nil {
+		t.Fatalf("ReadKeyRing(%s) error = %v", name, err)
+	}
+	return keyring
+}
+
+func testBundle() *Bundle {
+	return &Bundle{
+		Version:   BundleVersion,
+		Created:   time.Date(2020, time.September, 1, 0, 0, 0, 0, time.UTC),
+		Namespace: "default",
+		OnionServices: []OnionService{
+			{
+				Name:     "example",
+				Hostname: "25njqamcweflpvkl73j4szahhihoc4xt3ktcgjnpaingr5yhkenl5sid.onion",
+				KeySecret: torv1beta1.SecretReference{
+					Name: "example-key",
+					Key:  "hs_ed25519_secret_key",
+				},
+				Secrets: []Secret{
+					{
+						Name: "example-key",
+						Data: map[string][]byte{"hs_ed25519_secret_key": []byte("== ed25519v1-secret: type0 ==\x00\x00\x00")},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	recipients := readTestKeyRing(t, "recipient.pub.asc")
+	bundle := testBundle()
+
+	data, err := Encrypt(bundle, recipients)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		keyring    string
+		passphrase string
+		wantErr    bool
+	}{
+		{"passphrase", "recipient.asc", testPassphrase, false},
+		{"wrong passphrase", "recipient.asc", "wrong", true},
+		{"no passphrase", "recipient.asc", "", true},
+		{"public key only", "recipient.pub.asc", testPassphrase, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Decrypt unlocks the keys in place, so read them anew
+			keyring := readTestKeyRing(t, tt.keyring)
+			got, err := Decrypt(data, keyring, []byte(tt.passphrase))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, bundle) {
+				t.Errorf("Decrypt() = %+v, want %+v", got, bundle)
+			}
+		})
+	}
+}
+
+func TestDecryptGnuPG(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "bundle.pgp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Decrypt(data, readTestKeyRing(t, "recipient.asc"), []byte(testPassphrase))
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if want := testBundle(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Decrypt() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecryptInvalid(t *testing.T) {
+	keyring := readTestKeyRing(t, "recipient.asc")
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not armored", "bundle"},
+		{"public key", "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nxsBNBF9N\n-----END PGP PUBLIC KEY BLOCK-----\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt([]byte(tt.data), keyring, []byte(testPassphrase)); err == nil {
+				t.Errorf("Decrypt() returned no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Target stores bundles by name.
+type Target interface {
+	// Put writes the bundle data as name, replacing an existing one.
+	Put(ctx context.Context, name string, data []byte) error
+	// Get reads the bundle name.
+	Get(ctx context.Context, name string) ([]byte, error)
+}
+
+// Dir stores bundles as files in a directory.
+type Dir string
+
+var _ Target = Dir("")
+
+func (d Dir) path(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid bundle name %q", name)
+	}
+	return filepath.Join(string(d), name), nil
+}
+
+// Put writes data into a temporary file first, so an interrupted backup does
+// not leave a truncated bundle behind.
+func (d Dir) Put(_ context.Context, name string, data []byte) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return err
+	}
+
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// Get reads the file name.
+func (d Dir) Get(_ context.Context, name string) ([]byte, error) {
+	p, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(p)
+}
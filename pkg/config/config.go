@@ -2,23 +2,73 @@ package config
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
 	"text/template"
 
-	torv1alpha1 "github.com/marcus-sa/tor-operator/api/v1alpha1"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/keys"
 )
 
+const (
+	// ControlSocket is the unix socket the control port of tor listens on.
+	ControlSocket = "/run/tor/control/socket"
+	// ControlCookieFile is where tor writes the control port cookie.
+	ControlCookieFile = "/run/tor/control/cookie"
+	// MetricsPort is the address tor serves its own prometheus metrics on.
+	MetricsPort = "127.0.0.1:9035"
+	// SocksPort is the address of the SocksPort used for self-tests.
+	SocksPort = "127.0.0.1:9050"
+)
+
+// ErrSingleOnionSocksPort is returned for single onion services that need a
+// SocksPort, which tor refuses to open in non-anonymous mode.
+var ErrSingleOnionSocksPort = errors.New("singleOnion mode does not allow a SocksPort, disable selfTest")
+
+// ErrVersion2 is returned for version 2 onions without a migration. Tor
+// 0.4.6 and newer refuse to load a torrc with version 2 onions.
+var ErrVersion2 = errors.New("version 2 onions are not supported by tor 0.4.6 and newer, set migration or version 3")
+
 const configFormat = `
-SocksPort 0
-ControlPort 9051
+SocksPort {{ if .SocksPort }}{{ .SocksPort }}{{ else }}0{{ end }}
+ControlPort unix:{{ .ControlSocket }}
+{{ if .HashedControlPassword -}}
+HashedControlPassword {{ .HashedControlPassword }}
+{{ else -}}
+CookieAuthentication 1
+CookieAuthFile {{ .ControlCookieFile }}
+{{ end -}}
+MetricsPort {{ .MetricsPort }}
+MetricsPortPolicy accept 127.0.0.1
+{{ range .Options -}}
+{{ .Name }} {{ .Value }}
+{{ end -}}
+{{ range $service := .Services -}}
 HiddenServiceDir {{ .ServiceDir }}
 HiddenServiceVersion {{ .Version }}
+{{ range .Options -}}
+{{ .Name }} {{ .Value }}
+{{ end -}}
 {{ range .Ports }}
-HiddenServicePort {{ .PublicPort }} {{ $.ServiceClusterIP }}:{{ .ServicePort }}
+HiddenServicePort {{ .PublicPort }} {{ $service.ServiceClusterIP }}:{{ .ServicePort }}
 {{ end }}
+{{ end -}}
 `
 
 var configTemplate = template.Must(template.New("config").Parse(configFormat))
 
+type torConfig struct {
+	ControlSocket         string
+	ControlCookieFile     string
+	HashedControlPassword string
+	MetricsPort           string
+	SocksPort             string
+	Options               []option
+	Services              []onionService
+}
+
 type onionService struct {
 	ServiceName      string
 	ServiceNamespace string
@@ -26,6 +76,7 @@ type onionService struct {
 	ServiceDir       string
 	Version          int
 	Ports            []portPair
+	Options          []option
 }
 
 type portPair struct {
@@ -33,11 +84,124 @@ type portPair struct {
 	PublicPort  int32
 }
 
-func CreateTorConfigForService(onion *torv1alpha1.OnionService) (string, error) {
+// ServiceDir is the HiddenServiceDir of an OnionService with a tor daemon of
+// its own.
+const ServiceDir = "/run/tor/service"
+
+// PoolServiceDir returns the HiddenServiceDir of the member onion of a
+// TorDaemonPool.
+func PoolServiceDir(onion *torv1beta1.OnionService) string {
+	return path.Join("/run/tor/services", onion.Name)
+}
+
+// MigrationServiceDir returns the HiddenServiceDir of the version 3 onion
+// served next to the version 2 onion in dir during a migration.
+func MigrationServiceDir(dir string) string {
+	return dir + "-v3"
+}
+
+// RetiringServiceDir returns the HiddenServiceDir of the previous key served
+// next to the rotated key of the onion in dir.
+func RetiringServiceDir(dir string) string {
+	return dir + "-retiring"
+}
+
+// CreateTorConfigForService renders the torrc for onion. The control port is
+// protected by hashedControlPassword, or by cookie authentication if empty.
+func CreateTorConfigForService(onion *torv1beta1.OnionService, hashedControlPassword string) (string, error) {
+	services, global, socksPort, err := hiddenServices(onion, ServiceDir)
+	if err != nil {
+		return "", err
+	}
+
+	c := newTorConfig(hashedControlPassword)
+	c.Options = global
+	c.Services = services
+	if socksPort {
+		c.SocksPort = SocksPort
+	}
+
+	return c.render()
+}
+
+// CreateTorConfigForPool renders the torrc of a TorDaemonPool hosting all of
+// onions. Options that apply to the whole tor process, like the mode or the
+// bandwidth, have to be the same for all members.
+func CreateTorConfigForPool(onions []torv1beta1.OnionService, hashedControlPassword string) (string, error) {
+	c := newTorConfig(hashedControlPassword)
+
+	for i := range onions {
+		onion := &onions[i]
+		services, global, socksPort, err := hiddenServices(onion, PoolServiceDir(onion))
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", onion.Name, err)
+		}
+
+		if i > 0 && !reflect.DeepEqual(global, c.Options) {
+			return "", fmt.Errorf("%s: mode and global torOptions differ from %s", onion.Name, onions[0].Name)
+		}
+		c.Options = global
+
+		if socksPort {
+			c.SocksPort = SocksPort
+		}
+		c.Services = append(c.Services, services...)
+	}
+
+	return c.render()
+}
+
+func newTorConfig(hashedControlPassword string) *torConfig {
+	return &torConfig{
+		ControlSocket:         ControlSocket,
+		ControlCookieFile:     ControlCookieFile,
+		HashedControlPassword: hashedControlPassword,
+		MetricsPort:           MetricsPort,
+	}
+}
+
+func (c *torConfig) render() (string, error) {
+	var tmp bytes.Buffer
+	if err := configTemplate.Execute(&tmp, c); err != nil {
+		return "", err
+	}
+	return tmp.String(), nil
+}
+
+// hiddenServices returns the hidden service blocks of onion in dir. A
+// version 2 onion being migrated is only served as the version 3 block in
+// the MigrationServiceDir of dir, tor no longer loads version 2 onions. An
+// onion with a retiring key gets a block in the RetiringServiceDir of dir.
+func hiddenServices(onion *torv1beta1.OnionService, dir string) ([]onionService, []option, bool, error) {
+	service, global, socksPort, err := hiddenService(onion, dir)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if onion.Spec.Version == 2 {
+		service.ServiceDir = MigrationServiceDir(dir)
+		service.Version = 3
+	}
+
+	services := []onionService{service}
+
+	if rotation := onion.Status.KeyRotation; rotation != nil && rotation.PreviousKeySecret != nil {
+		retiring := service
+		retiring.ServiceDir = RetiringServiceDir(dir)
+		services = append(services, retiring)
+	}
+
+	return services, global, socksPort, nil
+}
+
+// hiddenService validates the spec of onion and returns its hidden service
+// block, the options it needs for the whole tor process and whether it needs
+// a SocksPort.
+func hiddenService(onion *torv1beta1.OnionService, dir string) (onionService, []option, bool, error) {
 	var ports []portPair
-	for _, p := range onion.Spec.Ports {
+	for _, p := range onion.Spec.Backend.Ports {
 		port := portPair{
-			ServicePort: p.TargetPort,
+			ServicePort: p.BackendPort(),
 			PublicPort:  p.PublicPort,
 		}
 		ports = append(ports, port)
@@ -47,15 +211,67 @@ func CreateTorConfigForService(onion *torv1alpha1.OnionService) (string, error)
 		ServiceName:      onion.Name,
 		ServiceNamespace: onion.Namespace,
 		ServiceClusterIP: onion.Status.TargetClusterIP,
-		ServiceDir:       "/run/tor/service",
+		ServiceDir:       dir,
 		Ports:            ports,
 		Version:          onion.Spec.Version,
 	}
 
-	var tmp bytes.Buffer
-	err := configTemplate.Execute(&tmp, s)
+	if onion.Spec.Version == 2 && onion.Spec.Migration == nil {
+		return s, nil, false, ErrVersion2
+	}
+
+	// the SocksPort is only needed to reach the onion from within the pod
+	socksPort := onion.Spec.SelfTest != nil
+
+	var global []option
+	if onion.Spec.Mode == torv1beta1.SingleOnionMode {
+		if socksPort {
+			return s, nil, false, ErrSingleOnionSocksPort
+		}
+		global = append(global,
+			boolOption("HiddenServiceSingleHopMode", true),
+			boolOption("HiddenServiceNonAnonymousMode", true),
+		)
+	}
+
+	if onion.Spec.TorOptions != nil && onion.Spec.TorOptions.MaxStreams != nil &&
+		onion.Spec.DoSDefense != nil && onion.Spec.DoSDefense.MaxStreams != nil {
+		return s, nil, false, errors.New("only one of torOptions.maxStreams and dosDefense.maxStreams can be set")
+	}
+
+	if onion.Spec.TorOptions != nil && onion.Spec.TorOptions.ExportCircuitID == torv1beta1.ExportCircuitIDHAProxy &&
+		onion.Spec.Version != 3 {
+		return s, nil, false, errors.New("torOptions.exportCircuitID requires version 3")
+	}
+
+	if onion.Spec.KeyRotation != nil && onion.Spec.Version != 3 {
+		return s, nil, false, errors.New("keyRotation requires version 3")
+	}
+
+	if onion.Spec.VanityPrefix != "" {
+		if onion.Spec.Version != 3 {
+			return s, nil, false, errors.New("vanityPrefix requires version 3")
+		}
+		if onion.Spec.KeyRotation != nil {
+			return s, nil, false, errors.New("only one of keyRotation and vanityPrefix can be set")
+		}
+		if err := keys.ValidateVanityPrefix(onion.Spec.VanityPrefix); err != nil {
+			return s, nil, false, err
+		}
+	}
+
+	torGlobal, service, err := torOptions(onion.Spec.TorOptions)
 	if err != nil {
-		return "", err
+		return s, nil, false, err
 	}
-	return tmp.String(), nil
+	global = append(global, torGlobal...)
+	s.Options = append(s.Options, service...)
+
+	dosDefense, err := dosDefenseOptions(onion.Spec.DoSDefense)
+	if err != nil {
+		return s, nil, false, err
+	}
+	s.Options = append(s.Options, dosDefense...)
+
+	return s, global, socksPort, nil
 }
@@ -0,0 +1,406 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func quantityPtr(s string) *resource.Quantity {
+	q := resource.MustParse(s)
+	return &q
+}
+
+func testOnion(name string) *torv1beta1.OnionService {
+	return &torv1beta1.OnionService{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: torv1beta1.OnionServiceSpec{
+			Version: 3,
+			Backend: torv1beta1.BackendSpec{
+				Selector: map[string]string{"app": name},
+				Ports: []torv1beta1.ServicePort{
+					{Name: "http", PublicPort: 80, TargetPort: intstr.FromInt(8080)},
+				},
+			},
+		},
+		Status: torv1beta1.OnionServiceStatus{TargetClusterIP: "10.0.0.1"},
+	}
+}
+
+// lines returns the non-empty lines of a torrc.
+func lines(torrc string) []string {
+	var result []string
+	for _, line := range strings.Split(torrc, "\n") {
+		if line != "" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+// torrcLines returns the lines of a torrc with the hidden service blocks of
+// services.
+func torrcLines(socksPort string, global []string, services ...[]string) []string {
+	result := []string{
+		"SocksPort " + socksPort,
+		"ControlPort unix:" + ControlSocket,
+		"CookieAuthentication 1",
+		"CookieAuthFile " + ControlCookieFile,
+		"MetricsPort " + MetricsPort,
+		"MetricsPortPolicy accept 127.0.0.1",
+	}
+	result = append(result, global...)
+	for _, service := range services {
+		result = append(result, service...)
+	}
+	return result
+}
+
+func TestCreateTorConfigForService(t *testing.T) {
+	service := func(dir string, options ...string) []string {
+		result := []string{"HiddenServiceDir " + dir, "HiddenServiceVersion 3"}
+		result = append(result, options...)
+		return append(result, "HiddenServicePort 80 10.0.0.1:8080")
+	}
+
+	tests := []struct {
+		name   string
+		update func(*torv1beta1.OnionService)
+		want   []string
+	}{
+		{
+			name: "defaults",
+			want: torrcLines("0", nil, service(ServiceDir)),
+		},
+		{
+			name: "tor options",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.TorOptions = &torv1beta1.TorOptions{
+					ConnectionPadding:     "off",
+					LogLevel:              "info",
+					BandwidthBurst:        quantityPtr("2Mi"),
+					BandwidthRate:         quantityPtr("1Mi"),
+					ExportCircuitID:       torv1beta1.ExportCircuitIDHAProxy,
+					MaxStreams:            int32Ptr(10),
+					NumIntroductionPoints: int32Ptr(5),
+				}
+			},
+			want: torrcLines("0",
+				[]string{
+					"BandwidthRate 1048576 bytes",
+					"BandwidthBurst 2097152 bytes",
+					"Log info stdout",
+					"ConnectionPadding 0",
+				},
+				service(ServiceDir,
+					"HiddenServiceNumIntroductionPoints 5",
+					"HiddenServiceMaxStreams 10",
+					"HiddenServiceExportCircuitID haproxy",
+				),
+			),
+		},
+		{
+			name: "connection padding auto",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.TorOptions = &torv1beta1.TorOptions{ConnectionPadding: "auto"}
+			},
+			want: torrcLines("0", []string{"ConnectionPadding auto"}, service(ServiceDir)),
+		},
+		{
+			name: "dos defense",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.DoSDefense = &torv1beta1.DoSDefenseSpec{
+					MaxStreams:             int32Ptr(20),
+					IntroDoSBurstPerSec:    int32Ptr(200),
+					IntroDoSRatePerSec:     int32Ptr(25),
+					IntroDoSDefenseEnabled: true,
+					PoWQueueBurst:          int32Ptr(2500),
+					PoWQueueRate:           int32Ptr(250),
+					PoWDefensesEnabled:     true,
+				}
+			},
+			want: torrcLines("0", nil, service(ServiceDir,
+				"HiddenServicePoWDefensesEnabled 1",
+				"HiddenServicePoWQueueRate 250",
+				"HiddenServicePoWQueueBurst 2500",
+				"HiddenServiceEnableIntroDoSDefense 1",
+				"HiddenServiceEnableIntroDoSRatePerSec 25",
+				"HiddenServiceEnableIntroDoSBurstPerSec 200",
+				"HiddenServiceMaxStreams 20",
+				"HiddenServiceMaxStreamsCloseCircuit 0",
+			)),
+		},
+		{
+			name: "disabled defenses ignore their options",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.DoSDefense = &torv1beta1.DoSDefenseSpec{
+					PoWQueueRate:       int32Ptr(250),
+					IntroDoSRatePerSec: int32Ptr(25),
+				}
+			},
+			want: torrcLines("0", nil, service(ServiceDir)),
+		},
+		{
+			name: "tor options before dos defense",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.TorOptions = &torv1beta1.TorOptions{NumIntroductionPoints: int32Ptr(3)}
+				o.Spec.DoSDefense = &torv1beta1.DoSDefenseSpec{
+					MaxStreams:             int32Ptr(20),
+					MaxStreamsCloseCircuit: true,
+				}
+			},
+			want: torrcLines("0", nil, service(ServiceDir,
+				"HiddenServiceNumIntroductionPoints 3",
+				"HiddenServiceMaxStreams 20",
+				"HiddenServiceMaxStreamsCloseCircuit 1",
+			)),
+		},
+		{
+			name: "single onion",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.Mode = torv1beta1.SingleOnionMode
+				o.Spec.TorOptions = &torv1beta1.TorOptions{LogLevel: "notice"}
+			},
+			want: torrcLines("0",
+				[]string{
+					"HiddenServiceSingleHopMode 1",
+					"HiddenServiceNonAnonymousMode 1",
+					"Log notice stdout",
+				},
+				service(ServiceDir),
+			),
+		},
+		{
+			name: "self-test",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.SelfTest = &torv1beta1.SelfTestSpec{}
+			},
+			want: torrcLines(SocksPort, nil, service(ServiceDir)),
+		},
+		{
+			name: "migration",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.Version = 2
+				o.Spec.Migration = &torv1beta1.MigrationSpec{}
+			},
+			want: torrcLines("0", nil, service(MigrationServiceDir(ServiceDir))),
+		},
+		{
+			name: "retiring key",
+			update: func(o *torv1beta1.OnionService) {
+				o.Status.KeyRotation = &torv1beta1.KeyRotationStatus{
+					PreviousKeySecret: &torv1beta1.SecretReference{Name: "example-key"},
+				}
+			},
+			want: torrcLines("0", nil, service(ServiceDir), service(RetiringServiceDir(ServiceDir))),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			onion := testOnion("example")
+			if tt.update != nil {
+				tt.update(onion)
+			}
+
+			torrc, err := CreateTorConfigForService(onion, "")
+			if err != nil {
+				t.Fatalf("CreateTorConfigForService() error = %v", err)
+			}
+			if got := lines(torrc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CreateTorConfigForService() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+
+			// the torrc is rendered the same way every time
+			again, err := CreateTorConfigForService(onion, "")
+			if err != nil || again != torrc {
+				t.Errorf("CreateTorConfigForService() is not deterministic")
+			}
+		})
+	}
+}
+
+func TestCreateTorConfigForServiceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(*torv1beta1.OnionService)
+		want   string
+	}{
+		{
+			name: "version 2",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.Version = 2
+			},
+			want: ErrVersion2.Error(),
+		},
+		{
+			name: "single onion with self-test",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.Mode = torv1beta1.SingleOnionMode
+				o.Spec.SelfTest = &torv1beta1.SelfTestSpec{}
+			},
+			want: ErrSingleOnionSocksPort.Error(),
+		},
+		{
+			name: "max streams set twice",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.TorOptions = &torv1beta1.TorOptions{MaxStreams: int32Ptr(10)}
+				o.Spec.DoSDefense = &torv1beta1.DoSDefenseSpec{MaxStreams: int32Ptr(20)}
+			},
+			want: "only one of torOptions.maxStreams and dosDefense.maxStreams can be set",
+		},
+		{
+			name: "export circuit id on version 2",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.Version = 2
+				o.Spec.Migration = &torv1beta1.MigrationSpec{}
+				o.Spec.TorOptions = &torv1beta1.TorOptions{ExportCircuitID: torv1beta1.ExportCircuitIDHAProxy}
+			},
+			want: "torOptions.exportCircuitID requires version 3",
+		},
+		{
+			name: "key rotation on version 2",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.Version = 2
+				o.Spec.Migration = &torv1beta1.MigrationSpec{}
+				o.Spec.KeyRotation = &torv1beta1.KeyRotationSpec{Token: "2020-09"}
+			},
+			want: "keyRotation requires version 3",
+		},
+		{
+			name: "vanity prefix on version 2",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.Version = 2
+				o.Spec.Migration = &torv1beta1.MigrationSpec{}
+				o.Spec.VanityPrefix = "onion"
+			},
+			want: "vanityPrefix requires version 3",
+		},
+		{
+			name: "vanity prefix with key rotation",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.VanityPrefix = "onion"
+				o.Spec.KeyRotation = &torv1beta1.KeyRotationSpec{Token: "2020-09"}
+			},
+			want: "only one of keyRotation and vanityPrefix can be set",
+		},
+		{
+			name: "invalid vanity prefix",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.VanityPrefix = "onion1"
+			},
+			want: `vanity prefix "onion1" contains '1', onion addresses only contain a-z and 2-7`,
+		},
+		{
+			name: "bandwidth burst below rate",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.TorOptions = &torv1beta1.TorOptions{
+					BandwidthRate:  quantityPtr("2Mi"),
+					BandwidthBurst: quantityPtr("1Mi"),
+				}
+			},
+			want: "bandwidthBurst 1Mi must not be lower than bandwidthRate 2Mi",
+		},
+		{
+			name: "intro dos burst below rate",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.DoSDefense = &torv1beta1.DoSDefenseSpec{
+					IntroDoSDefenseEnabled: true,
+					IntroDoSRatePerSec:     int32Ptr(25),
+					IntroDoSBurstPerSec:    int32Ptr(10),
+				}
+			},
+			want: "introDoSBurstPerSec 10 must not be lower than introDoSRatePerSec 25",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			onion := testOnion("example")
+			tt.update(onion)
+
+			_, err := CreateTorConfigForService(onion, "")
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("CreateTorConfigForService() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateTorConfigForPool(t *testing.T) {
+	a, b := testOnion("a"), testOnion("b")
+	b.Status.TargetClusterIP = "10.0.0.2"
+	b.Spec.DoSDefense = &torv1beta1.DoSDefenseSpec{PoWDefensesEnabled: true}
+
+	torrc, err := CreateTorConfigForPool([]torv1beta1.OnionService{*a, *b}, "16:HASH")
+	if err != nil {
+		t.Fatalf("CreateTorConfigForPool() error = %v", err)
+	}
+
+	want := []string{
+		"SocksPort 0",
+		"ControlPort unix:" + ControlSocket,
+		"HashedControlPassword 16:HASH",
+		"MetricsPort " + MetricsPort,
+		"MetricsPortPolicy accept 127.0.0.1",
+		"HiddenServiceDir " + PoolServiceDir(a),
+		"HiddenServiceVersion 3",
+		"HiddenServicePort 80 10.0.0.1:8080",
+		"HiddenServiceDir " + PoolServiceDir(b),
+		"HiddenServiceVersion 3",
+		"HiddenServicePoWDefensesEnabled 1",
+		"HiddenServicePort 80 10.0.0.2:8080",
+	}
+	if got := lines(torrc); !reflect.DeepEqual(got, want) {
+		t.Errorf("CreateTorConfigForPool() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	tests := []struct {
+		name   string
+		update func(*torv1beta1.OnionService)
+		want   string
+	}{
+		{
+			name: "invalid member",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.Version = 2
+			},
+			want: "b: " + ErrVersion2.Error(),
+		},
+		{
+			name: "different mode",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.Mode = torv1beta1.SingleOnionMode
+			},
+			want: "b: mode and global torOptions differ from a",
+		},
+		{
+			name: "different global options",
+			update: func(o *torv1beta1.OnionService) {
+				o.Spec.TorOptions = &torv1beta1.TorOptions{LogLevel: "info"}
+			},
+			want: "b: mode and global torOptions differ from a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testOnion("b")
+			tt.update(b)
+
+			_, err := CreateTorConfigForPool([]torv1beta1.OnionService{*a, *b}, "")
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("CreateTorConfigForPool() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+)
+
+// option is a single torrc line.
+type option struct {
+	Name  string
+	Value string
+}
+
+func boolOption(name string, value bool) option {
+	if value {
+		return option{name, "1"}
+	}
+	return option{name, "0"}
+}
+
+func int32Option(name string, value int32) option {
+	return option{name, fmt.Sprint(value)}
+}
+
+// dosDefenseOptions renders the per-service DoS defense options in a fixed
+// order. Options left unset in spec are omitted so tor uses its defaults.
+func dosDefenseOptions(spec *torv1beta1.DoSDefenseSpec) ([]option, error) {
+	if spec == nil {
+		return nil, nil
+	}
+
+	if spec.IntroDoSRatePerSec != nil && spec.IntroDoSBurstPerSec != nil &&
+		*spec.IntroDoSBurstPerSec < *spec.IntroDoSRatePerSec {
+		return nil, fmt.Errorf("introDoSBurstPerSec %d must not be lower than introDoSRatePerSec %d",
+			*spec.IntroDoSBurstPerSec, *spec.IntroDoSRatePerSec)
+	}
+
+	var options []option
+
+	if spec.PoWDefensesEnabled {
+		options = append(options, boolOption("HiddenServicePoWDefensesEnabled", true))
+		if spec.PoWQueueRate != nil {
+			options = append(options, int32Option("HiddenServicePoWQueueRate", *spec.PoWQueueRate))
+		}
+		if spec.PoWQueueBurst != nil {
+			options = append(options, int32Option("HiddenServicePoWQueueBurst", *spec.PoWQueueBurst))
+		}
+	}
+
+	if spec.IntroDoSDefenseEnabled {
+		options = append(options, boolOption("HiddenServiceEnableIntroDoSDefense", true))
+		if spec.IntroDoSRatePerSec != nil {
+			options = append(options, int32Option("HiddenServiceEnableIntroDoSRatePerSec", *spec.IntroDoSRatePerSec))
+		}
+		if spec.IntroDoSBurstPerSec != nil {
+			options = append(options, int32Option("HiddenServiceEnableIntroDoSBurstPerSec", *spec.IntroDoSBurstPerSec))
+		}
+	}
+
+	if spec.MaxStreams != nil {
+		options = append(options, int32Option("HiddenServiceMaxStreams", *spec.MaxStreams))
+		options = append(options, boolOption("HiddenServiceMaxStreamsCloseCircuit", spec.MaxStreamsCloseCircuit))
+	}
+
+	return options, nil
+}
+
+// torOptions renders the typed tor options in a fixed order, split into
+// options of the daemon and options of the hidden service.
+func torOptions(spec *torv1beta1.TorOptions) (global, service []option, err error) {
+	if spec == nil {
+		return nil, nil, nil
+	}
+
+	if spec.BandwidthRate != nil && spec.BandwidthBurst != nil && spec.BandwidthBurst.Cmp(*spec.BandwidthRate) < 0 {
+		return nil, nil, fmt.Errorf("bandwidthBurst %s must not be lower than bandwidthRate %s",
+			spec.BandwidthBurst, spec.BandwidthRate)
+	}
+
+	if spec.BandwidthRate != nil {
+		global = append(global, option{"BandwidthRate", fmt.Sprintf("%d bytes", spec.BandwidthRate.Value())})
+	}
+	if spec.BandwidthBurst != nil {
+		global = append(global, option{"BandwidthBurst", fmt.Sprintf("%d bytes", spec.BandwidthBurst.Value())})
+	}
+	if spec.LogLevel != "" {
+		global = append(global, option{"Log", spec.LogLevel + " stdout"})
+	}
+	switch spec.ConnectionPadding {
+	case "on":
+		global = append(global, boolOption("ConnectionPadding", true))
+	case "off":
+		global = append(global, boolOption("ConnectionPadding", false))
+	case "auto":
+		global = append(global, option{"ConnectionPadding", "auto"})
+	}
+
+	if spec.NumIntroductionPoints != nil {
+		service = append(service, int32Option("HiddenServiceNumIntroductionPoints", *spec.NumIntroductionPoints))
+	}
+	if spec.MaxStreams != nil {
+		service = append(service, int32Option("HiddenServiceMaxStreams", *spec.MaxStreams))
+	}
+	if spec.ExportCircuitID != "" {
+		service = append(service, option{"HiddenServiceExportCircuitID", spec.ExportCircuitID})
+	}
+
+	return global, service, nil
+}
@@ -0,0 +1,146 @@
+package control
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yawning/bulb"
+)
+
+// Conn is an authenticated connection to the control port of a tor daemon.
+type Conn struct {
+	*bulb.Conn
+}
+
+// Dial connects to the control port listening on either a unix socket or a
+// TCP address and authenticates against it. Cookie authentication is
+// preferred, the password is only used when tor does not offer a cookie.
+func Dial(socket, port, password string) (*Conn, error) {
+	var c *bulb.Conn
+	var err error
+
+	if socket != "" {
+		c, err = bulb.Dial("unix", socket)
+	} else if port != "" {
+		c, err = bulb.Dial("tcp4", port)
+	} else {
+		return nil, errors.New("no endpoint specified")
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to Tor")
+	}
+
+	if err = c.Authenticate(password); err != nil {
+		c.Close()
+		return nil, errors.Wrap(err, "Could not authenticate")
+	}
+
+	return &Conn{Conn: c}, nil
+}
+
+// LoadConf replaces the running configuration of tor with torrc. Tor
+// validates the whole configuration before applying any of it, so when an
+// error is returned the previous configuration is still in effect.
+func (c *Conn) LoadConf(torrc string) error {
+	if _, err := c.Request("+LOADCONF\r\n%s.", dotEncode(torrc)); err != nil {
+		return errors.Wrap(err, "LOADCONF rejected")
+	}
+
+	return nil
+}
+
+// GetInfo returns the value of a single-line GETINFO key.
+func (c *Conn) GetInfo(key string) (string, error) {
+	resp, err := c.Request("GETINFO %s", key)
+	if err != nil {
+		return "", errors.Wrapf(err, "GETINFO %s failed", key)
+	}
+
+	if len(resp.Data) != 1 {
+		return "", errors.Errorf("GETINFO %s returned unknown response", key)
+	}
+
+	vals := strings.SplitN(resp.Data[0], "=", 2)
+	if len(vals) != 2 {
+		return "", errors.Errorf("GETINFO %s returned invalid response", key)
+	}
+
+	return vals[1], nil
+}
+
+// BootstrapProgress returns how far tor got bootstrapping in percent.
+func (c *Conn) BootstrapProgress() (int, error) {
+	phase, err := c.GetInfo("status/bootstrap-phase")
+	if err != nil {
+		return 0, err
+	}
+
+	// NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY="Done"
+	for _, field := range strings.Fields(phase) {
+		if strings.HasPrefix(field, "PROGRESS=") {
+			return strconv.Atoi(strings.TrimPrefix(field, "PROGRESS="))
+		}
+	}
+
+	return 0, errors.Errorf("no progress in bootstrap phase %q", phase)
+}
+
+// dotEncode prepares a multi-line payload as described in section 2.2 of
+// control-spec.txt: CRLF line endings and leading dots doubled.
+func dotEncode(data string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(data, "\n"), "\n") {
+		if strings.HasPrefix(line, ".") {
+			b.WriteString(".")
+		}
+		b.WriteString(strings.TrimRight(line, "\r"))
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+// NewPassword generates a random control port password.
+func NewPassword() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", errors.Wrap(err, "failed to generate password")
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// HashPassword derives the HashedControlPassword value for password the same
+// way `tor --hash-password` does, using the salted and iterated S2K
+// specifier from RFC 2440 with SHA-1.
+func HashPassword(password string) (string, error) {
+	var salt [8]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return "", errors.Wrap(err, "failed to generate salt")
+	}
+	return hashPassword(password, salt), nil
+}
+
+// hashPassword hashes password with salt, see HashPassword.
+func hashPassword(password string, salt [8]byte) string {
+	// 0x60 encodes the 65536 byte iteration count tor always uses
+	const indicator = 0x60
+	count := (16 + (indicator & 15)) << ((indicator >> 4) + 6)
+
+	secret := append(salt[:], password...)
+	h := sha1.New()
+	for count > 0 {
+		n := len(secret)
+		if count < n {
+			n = count
+		}
+		h.Write(secret[:n])
+		count -= n
+	}
+
+	key := append(append(salt[:], indicator), h.Sum(nil)...)
+	return "16:" + strings.ToUpper(hex.EncodeToString(key))
+}
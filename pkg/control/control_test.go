@@ -0,0 +1,162 @@
+package control
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/yawning/bulb"
+)
+
+func TestHashPassword(t *testing.T) {
+	// output of `tor --hash-password`, which starts with the random salt
+	tests := []struct {
+		password string
+		hashed   string
+	}{
+		{"my_password", "16:E600ADC1B52C80BB6022A0E999A7734571A451EB6AE50FED489B72E3DF"},
+		{"password", "16:05834BCEDD478D1060F1D7E2CE98E9C13075E8D3061D702F63BCD674DE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			raw, err := hex.DecodeString(strings.TrimPrefix(tt.hashed, "16:"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var salt [8]byte
+			copy(salt[:], raw)
+
+			if got := hashPassword(tt.password, salt); got != tt.hashed {
+				t.Errorf("hashPassword(%q) = %s, want %s", tt.password, got, tt.hashed)
+			}
+		})
+	}
+}
+
+func TestHashPasswordSalt(t *testing.T) {
+	a, err := HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(a, "16:") || len(a) != len("16:")+2*(8+1+20) {
+		t.Errorf("HashPassword() = %s, want 16: followed by 29 bytes in hex", a)
+	}
+	if a == b {
+		t.Errorf("HashPassword() returned %s twice, want a random salt", a)
+	}
+}
+
+func TestNewPassword(t *testing.T) {
+	a, err := NewPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != 64 || a == b {
+		t.Errorf("NewPassword() = %s, %s, want two different 32 byte hex passwords", a, b)
+	}
+}
+
+func TestDotEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"single line", "SocksPort 0", "SocksPort 0\r\n"},
+		{"trailing newlines", "SocksPort 0\n\n", "SocksPort 0\r\n"},
+		{"crlf", "SocksPort 0\r\nControlPort 9051\r\n", "SocksPort 0\r\nControlPort 9051\r\n"},
+		{"leading dot", ".\n..hidden\nSocksPort 0", "..\r\n...hidden\r\nSocksPort 0\r\n"},
+		{"dot inside line", "HiddenServiceDir /var/lib/tor/a.b", "HiddenServiceDir /var/lib/tor/a.b\r\n"},
+		{"quoted value", "HiddenServiceDir \"/var/lib/tor/with space\"", "HiddenServiceDir \"/var/lib/tor/with space\"\r\n"},
+		{"empty line", "SocksPort 0\n\nControlPort 9051", "SocksPort 0\r\n\r\nControlPort 9051\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dotEncode(tt.data); got != tt.want {
+				t.Errorf("dotEncode(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+// serveLoadConf answers a single LOADCONF on conn with reply and returns the
+// received command through the channel.
+func serveLoadConf(t *testing.T, conn net.Conn, reply string) <-chan string {
+	received := make(chan string, 1)
+	go func() {
+		defer conn.Close()
+		var b strings.Builder
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Errorf("reading LOADCONF: %v", err)
+				close(received)
+				return
+			}
+			b.WriteString(line)
+			if line == ".\r\n" {
+				break
+			}
+		}
+		received <- b.String()
+		conn.Write([]byte(reply))
+	}()
+	return received
+}
+
+func TestLoadConf(t *testing.T) {
+	tests := []struct {
+		name    string
+		torrc   string
+		reply   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "accepted",
+			torrc: "SocksPort 0\n.ControlPort 9051\nHiddenServiceDir \"/var/lib/tor/a b\"\n",
+			reply: "250 OK\r\n",
+			want:  "+LOADCONF\r\nSocksPort 0\r\n..ControlPort 9051\r\nHiddenServiceDir \"/var/lib/tor/a b\"\r\n.\r\n",
+		},
+		{
+			name:    "rejected",
+			torrc:   "HiddenServicePort x",
+			reply:   "552 Invalid HiddenServicePort\r\n",
+			want:    "+LOADCONF\r\nHiddenServicePort x\r\n.\r\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			received := serveLoadConf(t, server, tt.reply)
+
+			c := &Conn{Conn: bulb.NewConn(client)}
+			defer c.Close()
+
+			err := c.LoadConf(tt.torrc)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadConf() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := <-received; got != tt.want {
+				t.Errorf("LoadConf() sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package control
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HS_DESC actions reported while publishing descriptors, see section 4.1.25
+// of control-spec.txt.
+const (
+	HSDescUpload   = "UPLOAD"
+	HSDescUploaded = "UPLOADED"
+	HSDescFailed   = "FAILED"
+)
+
+// HSDescEvent is an asynchronous HS_DESC event.
+type HSDescEvent struct {
+	Action  string
+	Address string
+	HSDir   string
+	Reason  string
+}
+
+// SetEvents subscribes the connection to the given asynchronous events,
+// replacing any earlier subscription.
+func (c *Conn) SetEvents(events ...string) error {
+	if _, err := c.Request("SETEVENTS %s", strings.Join(events, " ")); err != nil {
+		return errors.Wrap(err, "SETEVENTS rejected")
+	}
+
+	return nil
+}
+
+// ParseHSDescEvent parses the reply line of an HS_DESC event. The second
+// return value is false if reply is not an HS_DESC event.
+//
+//	HS_DESC Action HSAddress AuthType HsDir [DescriptorID] [REASON=Reason] ...
+func ParseHSDescEvent(reply string) (*HSDescEvent, bool) {
+	fields := strings.Fields(reply)
+	if len(fields) < 5 || fields[0] != "HS_DESC" {
+		return nil, false
+	}
+
+	event := &HSDescEvent{
+		Action:  fields[1],
+		Address: fields[2],
+		HSDir:   fields[4],
+	}
+	for _, field := range fields[5:] {
+		if strings.HasPrefix(field, "REASON=") {
+			event.Reason = strings.TrimPrefix(field, "REASON=")
+		}
+	}
+
+	return event, true
+}
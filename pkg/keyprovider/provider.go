@@ -0,0 +1,72 @@
+// Package keyprovider fetches the private keys of onion services from where
+// they are stored, a Kubernetes Secret by default.
+package keyprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TokenEnv is the environment variable the Vault token of a TokenSecret is
+// passed in.
+const TokenEnv = "VAULT_TOKEN"
+
+// ErrKeyNotFound is returned by Fetch if the store has no key at the
+// configured location.
+var ErrKeyNotFound = errors.New("key not found")
+
+// Provider fetches the private key of an onion service.
+type Provider interface {
+	// Fetch returns the private key in any of the formats accepted by
+	// keys.ParseSecretKey, or the private_key file of a version 2 onion.
+	Fetch(ctx context.Context) ([]byte, error)
+}
+
+// ForOnionService returns the provider of the private key of onion, or nil if
+// tor generates the key. Secrets are read with c.
+func ForOnionService(c client.Reader, onion *torv1beta1.OnionService) Provider {
+	if spec := onion.Spec.KeyProvider; spec != nil && spec.Vault != nil {
+		vault := NewVault(spec.Vault)
+		if spec.Vault.TokenSecret != nil {
+			vault.Token = os.Getenv(TokenEnv)
+		}
+		return vault
+	}
+
+	if ref := onion.Spec.PrivateKeySecret; ref != nil {
+		return &Secret{Client: c, Namespace: onion.Namespace, Ref: *ref}
+	}
+
+	return nil
+}
+
+// Secret reads the key from a Kubernetes Secret.
+type Secret struct {
+	Client    client.Reader
+	Namespace string
+	Ref       torv1beta1.SecretReference
+}
+
+var _ Provider = &Secret{}
+
+// Fetch returns the key Ref.Key of the Secret. A missing Secret is reported
+// with the NotFound error of the API server.
+func (s *Secret) Fetch(ctx context.Context) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := s.Client.Get(ctx, types.NamespacedName{Name: s.Ref.Name, Namespace: s.Namespace}, secret); err != nil {
+		return nil, err
+	}
+
+	data, ok := secret.Data[s.Ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("secret has no key %q: %w", s.Ref.Key, ErrKeyNotFound)
+	}
+	return data, nil
+}
@@ -0,0 +1,191 @@
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/marcus-sa/tor-operator/pkg/keys"
+)
+
+const (
+	defaultVaultMount    = "secret"
+	defaultVaultAuthPath = "kubernetes"
+	defaultVaultField    = keys.SecretKeyFileName
+
+	// controlPortPrefix starts keys in the format of ADD_ONION, which are
+	// stored in Vault as is.
+	controlPortPrefix = "ED25519-V3:"
+
+	// ServiceAccountTokenFile is the token of the service account of the
+	// pod, which logs in with the Kubernetes auth method of Vault.
+	ServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// Vault reads the key from a KV secrets engine of HashiCorp Vault. Without a
+// Token it logs in with the Kubernetes auth method first.
+type Vault struct {
+	// Address of the Vault server, e.g. http://127.0.0.1:8200.
+	Address string
+	Mount   string
+	Path    string
+	// Field of the secret holding the key, base64 encoded unless it is in
+	// the format of the control port.
+	Field     string
+	KVVersion int
+
+	// Role and AuthPath are used to log in if Token is empty, with the JWT
+	// read from TokenFile.
+	Role      string
+	AuthPath  string
+	TokenFile string
+	Token     string
+
+	// Client sends the requests, defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+var _ Provider = &Vault{}
+
+// NewVault returns the provider of spec with its defaults applied.
+func NewVault(spec *torv1beta1.VaultKeyProvider) *Vault {
+	v := &Vault{
+		Address:   spec.Address,
+		Mount:     spec.Mount,
+		Path:      spec.Path,
+		Field:     spec.Field,
+		KVVersion: spec.KVVersion,
+		Role:      spec.Role,
+		AuthPath:  spec.AuthPath,
+		TokenFile: ServiceAccountTokenFile,
+	}
+	if v.Mount == "" {
+		v.Mount = defaultVaultMount
+	}
+	if v.Field == "" {
+		v.Field = defaultVaultField
+	}
+	if v.KVVersion == 0 {
+		v.KVVersion = 2
+	}
+	if v.AuthPath == "" {
+		v.AuthPath = defaultVaultAuthPath
+	}
+	return v
+}
+
+// Fetch reads Field of the secret at Path.
+func (v *Vault) Fetch(ctx context.Context) ([]byte, error) {
+	token := v.Token
+	if token == "" {
+		var err error
+		if token, err = v.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	path := v.Mount + "/" + strings.TrimPrefix(v.Path, "/")
+	if v.KVVersion == 2 {
+		path = v.Mount + "/data/" + strings.TrimPrefix(v.Path, "/")
+	}
+
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, path, token, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	fields := resp.Data
+	if v.KVVersion == 2 {
+		// deleted versions of KV version 2 have no data
+		fields, _ = resp.Data["data"].(map[string]interface{})
+	}
+
+	value, ok := fields[v.Field].(string)
+	if !ok {
+		return nil, fmt.Errorf("vault secret %s has no field %q: %w", path, v.Field, ErrKeyNotFound)
+	}
+	if strings.HasPrefix(value, controlPortPrefix) {
+		return []byte(value), nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("field %q of vault secret %s is not base64 encoded: %v", v.Field, path, err)
+	}
+	return data, nil
+}
+
+// login exchanges the service account token for a Vault token.
+func (v *Vault) login(ctx context.Context) (string, error) {
+	if v.Role == "" {
+		return "", fmt.Errorf("vault needs a token or a role to log in with")
+	}
+
+	jwt, err := ioutil.ReadFile(v.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read service account token: %v", err)
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"role": v.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, "auth/"+v.AuthPath+"/login", "", body, &resp); err != nil {
+		return "", fmt.Errorf("vault login failed: %v", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault login returned no token")
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+func (v *Vault) do(ctx context.Context, method, path, token string, body []byte, out interface{}) error {
+	url := strings.TrimSuffix(v.Address, "/") + "/v1/" + path
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("vault secret %s does not exist: %w", path, ErrKeyNotFound)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,177 @@
+// Package keys handles the keys and addresses of version 3 onion services in
+// the formats used by tor.
+package keys
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/edwards25519"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// SecretKeyFileName is the name of the secret key in a HiddenServiceDir.
+	SecretKeyFileName = "hs_ed25519_secret_key"
+
+	// secretKeyHeader starts the hs_ed25519_secret_key file written by tor.
+	secretKeyHeader = "== ed25519v1-secret: type0 ==\x00\x00\x00"
+
+	// controlPortPrefix starts keys in the format of ADD_ONION.
+	controlPortPrefix = "ED25519-V3:"
+
+	version = 3
+)
+
+// Formats of secret keys accepted by ParseSecretKey.
+const (
+	// FormatTor is the hs_ed25519_secret_key file written by tor.
+	FormatTor = "tor"
+	// FormatExpanded is the raw 64 byte expanded secret key.
+	FormatExpanded = "expanded"
+	// FormatPrivateKey is the 64 byte seed and public key of crypto/ed25519.
+	FormatPrivateKey = "privateKey"
+	// FormatSeed is a raw 32 byte ed25519 seed.
+	FormatSeed = "seed"
+	// FormatControlPort is the base64 encoded expanded key prefixed with
+	// ED25519-V3: as used by ADD_ONION.
+	FormatControlPort = "controlPort"
+)
+
+// Key is the expanded ed25519 secret key of a version 3 onion service along
+// with its public key. Tor only stores the expanded form, so the seed is not
+// kept.
+type Key struct {
+	secret [64]byte
+	public ed25519.PublicKey
+}
+
+// GenerateKey creates a new random key using entropy from rand.
+func GenerateKey(rand io.Reader) (*Key, error) {
+	public, private, err := ed25519.GenerateKey(rand)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		secret: expand(private.Seed()),
+		public: public,
+	}, nil
+}
+
+// ParseSecretKey reads a version 3 secret key from data in any of the
+// supported formats and returns the key along with the detected format.
+func ParseSecretKey(data []byte) (*Key, string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(controlPortPrefix)):
+		encoded := strings.TrimSpace(string(data[len(controlPortPrefix):]))
+		expanded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid base64 in %s key: %v", controlPortPrefix, err)
+		}
+		if len(expanded) != 64 {
+			return nil, "", fmt.Errorf("%s key has %d bytes instead of 64", controlPortPrefix, len(expanded))
+		}
+		key, err := fromExpanded(expanded)
+		return key, FormatControlPort, err
+
+	case bytes.HasPrefix(data, []byte(secretKeyHeader[:len(secretKeyHeader)-3])):
+		if len(data) != len(secretKeyHeader)+64 || !bytes.HasPrefix(data, []byte(secretKeyHeader)) {
+			return nil, "", fmt.Errorf("truncated or corrupt hs_ed25519_secret_key of %d bytes", len(data))
+		}
+		key, err := fromExpanded(data[len(secretKeyHeader):])
+		return key, FormatTor, err
+
+	case len(data) == ed25519.SeedSize:
+		private := ed25519.NewKeyFromSeed(data)
+		return &Key{
+			secret: expand(data),
+			public: private.Public().(ed25519.PublicKey),
+		}, FormatSeed, nil
+
+	case len(data) == 64:
+		// a crypto/ed25519 private key ends with the public key of its seed
+		private := ed25519.NewKeyFromSeed(data[:ed25519.SeedSize])
+		if bytes.Equal(private, data) {
+			return &Key{
+				secret: expand(data[:ed25519.SeedSize]),
+				public: private.Public().(ed25519.PublicKey),
+			}, FormatPrivateKey, nil
+		}
+		key, err := fromExpanded(data)
+		return key, FormatExpanded, err
+	}
+
+	return nil, "", fmt.Errorf("unknown key format of %d bytes, expected an hs_ed25519_secret_key, "+
+		"a 64 byte expanded key or a 32 byte seed", len(data))
+}
+
+// fromExpanded validates an expanded secret key and derives its public key.
+func fromExpanded(expanded []byte) (*Key, error) {
+	// the scalar of an expanded key is clamped
+	if expanded[0]&7 != 0 || expanded[31]&128 != 0 || expanded[31]&64 == 0 {
+		return nil, fmt.Errorf("expanded key is not clamped, it is not an ed25519 secret key")
+	}
+
+	// the standard library only derives public keys from seeds, while tor
+	// stores the expanded scalar
+	scalar, err := edwards25519.NewScalar().SetBytesWithClamping(expanded[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{}
+	copy(key.secret[:], expanded)
+	key.public = new(edwards25519.Point).ScalarBaseMult(scalar).Bytes()
+	return key, nil
+}
+
+// expand derives the expanded secret key tor uses from an ed25519 seed.
+func expand(seed []byte) [64]byte {
+	secret := sha512.Sum512(seed)
+	secret[0] &= 248
+	secret[31] &= 127
+	secret[31] |= 64
+	return secret
+}
+
+// SecretKeyFile returns the content of the hs_ed25519_secret_key file.
+func (k *Key) SecretKeyFile() []byte {
+	return append([]byte(secretKeyHeader), k.secret[:]...)
+}
+
+// PublicKey returns the public key of the onion.
+func (k *Key) PublicKey() ed25519.PublicKey {
+	return k.public
+}
+
+// Hostname returns the onion address of the key, including the .onion
+// suffix.
+func (k *Key) Hostname() string {
+	return Hostname(k.public)
+}
+
+// Hostname returns the version 3 onion address of public, including the
+// .onion suffix.
+func Hostname(public ed25519.PublicKey) string {
+	// CHECKSUM = H(".onion checksum" | PUBKEY | VERSION)[:2]
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(public)
+	h.Write([]byte{version})
+	checksum := h.Sum(nil)[:2]
+
+	// onion_address = base32(PUBKEY | CHECKSUM | VERSION) + ".onion"
+	address := make([]byte, 0, ed25519.PublicKeySize+3)
+	address = append(address, public...)
+	address = append(address, checksum...)
+	address = append(address, version)
+
+	return strings.ToLower(base32.StdEncoding.EncodeToString(address)) + ".onion"
+}
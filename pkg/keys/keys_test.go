@@ -0,0 +1,149 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+// The seed and public key of TEST 1 of RFC 8032. Tor uses the public key in
+// test_build_address of src/test/test_hs_common.c, along with its address.
+const (
+	testSeed     = "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"
+	testPublic   = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+	testHostname = "25njqamcweflpvkl73j4szahhihoc4xt3ktcgjnpaingr5yhkenl5sid.onion"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testExpanded returns the expanded secret key tor derives from the seed,
+// clamped as in ed25519_extsk of tor.
+func testExpanded(t *testing.T) []byte {
+	t.Helper()
+	h := sha512.Sum512(mustDecodeHex(t, testSeed))
+	h[0] &= 248
+	h[31] &= 63
+	h[31] |= 64
+	return h[:]
+}
+
+func TestHostname(t *testing.T) {
+	public := ed25519.PublicKey(mustDecodeHex(t, testPublic))
+	if got := Hostname(public); got != testHostname {
+		t.Errorf("Hostname() = %s, want %s", got, testHostname)
+	}
+}
+
+func TestParseSecretKey(t *testing.T) {
+	seed := mustDecodeHex(t, testSeed)
+	expanded := testExpanded(t)
+	torFile := append([]byte("== ed25519v1-secret: type0 ==\x00\x00\x00"), expanded...)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+	}{
+		{"tor", torFile, FormatTor},
+		{"expanded", expanded, FormatExpanded},
+		{"privateKey", ed25519.NewKeyFromSeed(seed), FormatPrivateKey},
+		{"seed", seed, FormatSeed},
+		{"controlPort", []byte("ED25519-V3:" + base64.StdEncoding.EncodeToString(expanded)), FormatControlPort},
+		{"controlPort with newline", []byte("ED25519-V3:" + base64.StdEncoding.EncodeToString(expanded) + "\n"), FormatControlPort},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, format, err := ParseSecretKey(tt.data)
+			if err != nil {
+				t.Fatalf("ParseSecretKey() error = %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("format = %s, want %s", format, tt.format)
+			}
+			if got := hex.EncodeToString(key.PublicKey()); got != testPublic {
+				t.Errorf("PublicKey() = %s, want %s", got, testPublic)
+			}
+			if got := key.Hostname(); got != testHostname {
+				t.Errorf("Hostname() = %s, want %s", got, testHostname)
+			}
+			if got := key.SecretKeyFile(); !bytes.Equal(got, torFile) {
+				t.Errorf("SecretKeyFile() = %x, want %x", got, torFile)
+			}
+		})
+	}
+}
+
+func TestParseSecretKeyErrors(t *testing.T) {
+	expanded := testExpanded(t)
+	torFile := append([]byte("== ed25519v1-secret: type0 ==\x00\x00\x00"), expanded...)
+
+	unclamped := append([]byte{}, expanded...)
+	unclamped[0] |= 1
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown length", make([]byte, 48)},
+		{"truncated tor file", torFile[:len(torFile)-1]},
+		{"tor file with trailing data", append(append([]byte{}, torFile...), 0)},
+		{"corrupt tor header", append([]byte("== ed25519v1-secret: type0 ==\x00\x00\x01"), expanded...)},
+		{"unclamped expanded", unclamped},
+		{"invalid base64", []byte("ED25519-V3:not base64!")},
+		{"short control port key", []byte("ED25519-V3:" + base64.StdEncoding.EncodeToString(expanded[:32]))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key, format, err := ParseSecretKey(tt.data); err == nil {
+				t.Errorf("ParseSecretKey() = %s (%s), want an error", key.Hostname(), format)
+			}
+		})
+	}
+}
+
+// TestExpandedPublicKey checks that the public key derived from the expanded
+// key matches the one crypto/ed25519 derives from the seed.
+func TestExpandedPublicKey(t *testing.T) {
+	for i := 0; i < 32; i++ {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret := expand(private.Seed())
+		key, err := fromExpanded(secret[:])
+		if err != nil {
+			t.Fatalf("fromExpanded() error = %v", err)
+		}
+		if !bytes.Equal(key.PublicKey(), public) {
+			t.Fatalf("fromExpanded(%x) = %x, want %x", secret, key.PublicKey(), public)
+		}
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	key, err := GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, format, err := ParseSecretKey(key.SecretKeyFile())
+	if err != nil {
+		t.Fatalf("ParseSecretKey() error = %v", err)
+	}
+	if format != FormatTor || parsed.Hostname() != key.Hostname() {
+		t.Errorf("ParseSecretKey() = %s (%s), want %s (%s)", parsed.Hostname(), format, key.Hostname(), FormatTor)
+	}
+}
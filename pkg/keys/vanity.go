@@ -0,0 +1,140 @@
+package keys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxVanityPrefixLength bounds the prefixes accepted by SearchVanity, every
+// further character multiplies the expected search time by 32.
+const MaxVanityPrefixLength = 6
+
+// base32Alphabet are the characters of an onion address.
+const base32Alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+// ValidateVanityPrefix checks that an onion address can start with prefix.
+func ValidateVanityPrefix(prefix string) error {
+	if len(prefix) > MaxVanityPrefixLength {
+		return fmt.Errorf("vanity prefix %q is longer than %d characters", prefix, MaxVanityPrefixLength)
+	}
+	for _, c := range prefix {
+		if !strings.ContainsRune(base32Alphabet, c) {
+			return fmt.Errorf("vanity prefix %q contains %q, onion addresses only contain a-z and 2-7", prefix, c)
+		}
+	}
+	return nil
+}
+
+// VanityAttempts returns the expected number of keys generated until the
+// address of one starts with prefix.
+func VanityAttempts(prefix string) uint64 {
+	return 1 << (5 * uint(len(prefix)))
+}
+
+// vanityBatch is the number of keys a worker generates before it gives its
+// slot of the limiter to the workers of other searches.
+const vanityBatch = 1024
+
+// VanityLimiter bounds the number of goroutines generating keys across all
+// searches sharing it.
+type VanityLimiter chan struct{}
+
+// NewVanityLimiter returns a limiter running at most workers goroutines at a
+// time.
+func NewVanityLimiter(workers int) VanityLimiter {
+	if workers < 1 {
+		workers = 1
+	}
+	return make(VanityLimiter, workers)
+}
+
+// SearchVanity generates keys until the address of one starts with prefix or
+// ctx is done. It starts as many workers as limiter admits, which take turns
+// with the workers of other searches sharing limiter. attempts counts the
+// generated keys and may be read concurrently with atomic.LoadUint64.
+func SearchVanity(ctx context.Context, prefix string, limiter VanityLimiter, attempts *uint64) (*Key, error) {
+	if err := ValidateVanityPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	// the prefix only depends on the first bytes of the public key
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	want := []byte(strings.ToUpper(prefix))
+	prefixBytes := (len(prefix)*5 + 7) / 8
+
+	search, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		found *Key
+		err   error
+	)
+
+	// generate returns false once the search is over
+	generate := func(seed, encoded []byte) bool {
+		for i := 0; i < vanityBatch; i++ {
+			if search.Err() != nil {
+				return false
+			}
+			if _, e := rand.Read(seed); e != nil {
+				once.Do(func() { err = e })
+				cancel()
+				return false
+			}
+
+			public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+			atomic.AddUint64(attempts, 1)
+
+			encoding.Encode(encoded, public[:prefixBytes])
+			if string(encoded[:len(want)]) != string(want) {
+				continue
+			}
+
+			once.Do(func() {
+				found = &Key{secret: expand(seed), public: public}
+			})
+			cancel()
+			return false
+		}
+		return true
+	}
+
+	for i := 0; i < cap(limiter); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			seed := make([]byte, ed25519.SeedSize)
+			encoded := make([]byte, encoding.EncodedLen(prefixBytes))
+			for {
+				select {
+				case limiter <- struct{}{}:
+				case <-search.Done():
+					return
+				}
+				more := generate(seed, encoded)
+				<-limiter
+				if !more {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if found != nil {
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ctx.Err()
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"context"
+	torv1beta1 "github.com/marcus-sa/tor-operator/api/v1beta1"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"strconv"
+)
+
+const operatorNamespace = "tor_operator"
+
+// The sources of the keys counted by KeysGenerated.
+const (
+	KeySourceTor       = "tor"
+	KeySourceMigration = "migration"
+	KeySourceRotation  = "rotation"
+	KeySourceVanity    = "vanity"
+)
+
+var (
+	// ReconcileErrors counts failed reconciliations of the resources
+	// owned by an OnionService, labeled with the kind of the resource.
+	ReconcileErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: operatorNamespace,
+			Name:      "reconcile_errors_total",
+			Help:      "Count of errors reconciling resources owned by OnionServices",
+		},
+		[]string{"resource"},
+	)
+	// HostnameLatency observes the time it took from creating an
+	// OnionService until its hostname was published in the status.
+	HostnameLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: operatorNamespace,
+			Name:      "hostname_available_seconds",
+			Help:      "Time from creation of an OnionService until its hostname is available",
+			Buckets:   []float64{5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+		},
+	)
+	// KeysGenerated counts onion keys generated for OnionServices, labeled
+	// with whether tor generated the key on startup or the operator for a
+	// migration, rotation or vanity search.
+	KeysGenerated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: operatorNamespace,
+			Name:      "keys_generated_total",
+			Help:      "Count of onion keys generated for OnionServices",
+		},
+		[]string{"version", "source"},
+	)
+
+	onionServicesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(operatorNamespace, "", "onion_services"),
+		"Count of OnionServices by version and readiness",
+		[]string{"version", "ready"}, nil,
+	)
+)
+
+// OnionServiceCollector reports the number of OnionServices known to the
+// cache of the manager whenever metrics are scraped.
+type OnionServiceCollector struct {
+	Client client.Reader
+}
+
+// Describe implements prometheus.Collector.
+func (c *OnionServiceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- onionServicesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *OnionServiceCollector) Collect(ch chan<- prometheus.Metric) {
+	list := &torv1beta1.OnionServiceList{}
+	if err := c.Client.List(context.Background(), list); err != nil {
+		ch <- prometheus.NewInvalidMetric(onionServicesDesc, err)
+		return
+	}
+
+	type key struct {
+		version int
+		ready   bool
+	}
+	counts := map[key]int{}
+	for _, onion := range list.Items {
+		counts[key{onion.Spec.Version, onion.Status.Hostname != ""}]++
+	}
+
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(onionServicesDesc, prometheus.GaugeValue, float64(count),
+			strconv.Itoa(k.version), strconv.FormatBool(k.ready))
+	}
+}
+
+// RegisterOnionServiceMetrics adds the operator metrics to the registry
+// served on the metrics endpoint of the manager.
+func RegisterOnionServiceMetrics(reader client.Reader) error {
+	for _, c := range []prometheus.Collector{
+		ReconcileErrors,
+		HostnameLatency,
+		KeysGenerated,
+		&OnionServiceCollector{Client: reader},
+	} {
+		if err := metrics.Registry.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
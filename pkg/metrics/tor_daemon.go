@@ -1,169 +1,410 @@
 package metrics
 
 import (
+	"fmt"
+	"github.com/marcus-sa/tor-operator/pkg/control"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/yawning/bulb"
+	"github.com/prometheus/common/expfmt"
+	"net/http"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+const namespace = "tor_daemon"
+
+// labels identifies the OnionService a tor daemon belongs to.
+var labels = []string{"onion_name", "onion_namespace"}
+
 var (
-	circuitsCountGauge = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "circuits",
-			Help: "Count of currently open circuits",
-		},
+	circuitsCountDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "circuits"),
+		"Count of currently open circuits",
+		labels, nil,
 	)
-	streamsCountGauge = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "streams",
-			Help: "Count of currently open streams",
-		},
+	streamsCountDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "streams"),
+		"Count of currently open streams",
+		labels, nil,
 	)
-	orconnsCountGauge = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "orconns",
-			Help: "Count of currently open ORConns",
-		},
+	orconnsCountDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "orconns"),
+		"Count of currently open ORConns",
+		labels, nil,
+	)
+	trafficReadDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "traffic_read_bytes_total"),
+		"Total traffic read",
+		labels, nil,
+	)
+	trafficWrittenDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "traffic_written_bytes_total"),
+		"Total traffic written",
+		labels, nil,
+	)
+	bootstrapProgressDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "bootstrap_progress"),
+		"Bootstrap progress of the tor daemon in percent",
+		labels, nil,
+	)
+	descriptorUploadsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "hs", "descriptor_uploads_total"),
+		"Count of hidden service descriptor uploads to HSDirs by onion address and result",
+		append(labels, "onion_address", "result"), nil,
+	)
+	introPointsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "hs", "introduction_points"),
+		"Count of established introduction points",
+		labels, nil,
 	)
-	trafficReadCounter = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: "traffic_read",
-			Help: "Total traffic read",
+	rendezvousCircuitsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "hs", "rendezvous_circuits"),
+		"Count of currently joined rendezvous circuits",
+		labels, nil,
+	)
+	rejectedIntroductionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "hs", "introductions_rejected_total"),
+		"Count of introduction requests rejected by the DoS defenses of the hidden service",
+		labels, nil,
+	)
+	powSuggestedEffortDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "hs", "pow_suggested_effort"),
+		"Proof-of-work effort currently suggested to clients",
+		labels, nil,
+	)
+)
+
+var (
+	// SelfTestSuccess reports whether the last self-test reached the onion
+	// on a public port.
+	SelfTestSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "hs",
+			Name:      "self_test_success",
+			Help:      "Whether the last self-test reached the onion on a public port",
 		},
+		append(labels, "port"),
 	)
-	trafficWrittenCounter = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: "traffic_written",
-			Help: "Total traffic written",
+	// SelfTestDuration observes how long self-tests of a public port took.
+	SelfTestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "hs",
+			Name:      "self_test_duration_seconds",
+			Help:      "Duration of self-tests of the onion on a public port",
+			Buckets:   []float64{1, 2.5, 5, 10, 20, 30, 60},
 		},
+		append(labels, "port"),
 	)
 )
 
+// TorDaemonMetricsExporter is a prometheus.Collector that queries the control
+// port of the tor daemon whenever metrics are scraped.
 type TorDaemonMetricsExporter struct {
-	Bulb *bulb.Conn
-}
+	OnionServiceName      string
+	OnionServiceNamespace string
+	Socket                string
+	Port                  string
+	Password              string
+	// MetricsPort is the address of the MetricsPort of tor, hidden service
+	// DoS metrics are only reported when it is set.
+	MetricsPort string
+	Conn        *control.Conn
 
-func (e *TorDaemonMetricsExporter) Connect(socket, port string) error  {
-	var err error
+	mu sync.Mutex
 
-	if socket != "" {
-		e.Bulb, err = bulb.Dial("unix", socket)
-	} else if port != "" {
-		e.Bulb, err = bulb.Dial("tcp4", port)
-	} else {
-		return errors.New("no endpoint specified")
-	}
+	// uploads counts descriptor uploads by onion address, a daemon serves
+	// several addresses in a pool or during a migration or key rotation
+	uploadsMu sync.Mutex
+	uploads   map[string]*descriptorUploads
+}
 
+type descriptorUploads struct {
+	succeeded, failed uint64
+}
+
+func (e *TorDaemonMetricsExporter) Connect() error {
+	conn, err := control.Dial(e.Socket, e.Port, e.Password)
 	if err != nil {
-		return errors.Wrap(err, "failed to connect to Tor")
+		return err
 	}
 
-	//c.Debug(true)
-	if err = e.Bulb.Authenticate(""); err != nil {
-		return errors.Wrap(err, "Could not authenticate")
+	if err := conn.SetEvents("HS_DESC"); err != nil {
+		conn.Close()
+		return err
 	}
+	conn.StartAsyncReader()
+	go e.watchEvents(conn)
 
+	e.Conn = conn
 	return nil
 }
 
-func (e* TorDaemonMetricsExporter) Reconnect(socket, port string) error {
-	if err := e.Close(); err != nil {
-		return err
+func (e *TorDaemonMetricsExporter) Close() error {
+	if e.Conn == nil {
+		return nil
 	}
 
-	return e.Connect(socket, port)
+	err := e.Conn.Close()
+	e.Conn = nil
+	return err
 }
 
-func (e *TorDaemonMetricsExporter) Close() error {
-	defer e.Bulb.Close()
-	return nil
+// watchEvents counts descriptor uploads until the connection is closed.
+func (e *TorDaemonMetricsExporter) watchEvents(conn *control.Conn) {
+	for {
+		resp, err := conn.NextEvent()
+		if err != nil {
+			return
+		}
+
+		event, ok := control.ParseHSDescEvent(resp.Reply)
+		if !ok {
+			continue
+		}
+
+		e.countUpload(event)
+	}
 }
 
-func (e *TorDaemonMetricsExporter) getInfoFloat(val string) (float64, error) {
-	resp, err := e.Bulb.Request("GETINFO " + val)
-	if err != nil {
-		return -1, err
+// countUpload counts the result of a descriptor upload for its address.
+func (e *TorDaemonMetricsExporter) countUpload(event *control.HSDescEvent) {
+	if event.Action != control.HSDescUploaded && event.Action != control.HSDescFailed {
+		return
 	}
 
-	if len(resp.Data) != 1 {
-		return -1, errors.Errorf("GETINFO %s returned unknown response", val)
+	e.uploadsMu.Lock()
+	defer e.uploadsMu.Unlock()
+
+	if e.uploads == nil {
+		e.uploads = map[string]*descriptorUploads{}
+	}
+	uploads, ok := e.uploads[event.Address]
+	if !ok {
+		uploads = &descriptorUploads{}
+		e.uploads[event.Address] = uploads
+	}
+	if event.Action == control.HSDescUploaded {
+		uploads.succeeded++
+	} else {
+		uploads.failed++
 	}
+}
 
-	vals := strings.SplitN(resp.Data[0], "=", 2)
-	if len(vals) != 2 {
-		return -1, errors.Errorf("GETINFO %s returned invalid response", val)
+func (e *TorDaemonMetricsExporter) getInfoFloat(val string) (float64, error) {
+	info, err := e.Conn.GetInfo(val)
+	if err != nil {
+		return -1, err
 	}
 
-	return strconv.ParseFloat(vals[1], 64)
+	return strconv.ParseFloat(info, 64)
 }
 
-// Do a GETINFO %val, return the number of lines that match %match
-func (e *TorDaemonMetricsExporter) linesWithMatch(val, match string) (int, error) {
-	resp, err := e.Bulb.Request("GETINFO " + val)
+// Do a GETINFO %val, return the number of lines that contain all of %matches
+func (e *TorDaemonMetricsExporter) linesWithMatch(val string, matches ...string) (int, error) {
+	resp, err := e.Conn.Request("GETINFO " + val)
 	if err != nil {
-		return -1, errors.Wrap(err, "GETINFO circuit-status failed")
+		return -1, errors.Wrapf(err, "GETINFO %s failed", val)
 	}
 	if len(resp.Data) < 2 {
 		return 0, nil
 	}
 
 	ct := 0
+lines:
 	for _, line := range strings.Split(resp.Data[1], "\n") {
-		if strings.Contains(line, match) {
-			ct++
+		for _, match := range matches {
+			if !strings.Contains(line, match) {
+				continue lines
+			}
 		}
+		ct++
 	}
 
 	return ct, nil
 }
 
-func (e *TorDaemonMetricsExporter) scrapeTraffic() error {
+func (e *TorDaemonMetricsExporter) labelValues(extra ...string) []string {
+	return append([]string{e.OnionServiceName, e.OnionServiceNamespace}, extra...)
+}
+
+func (e *TorDaemonMetricsExporter) gauge(ch chan<- prometheus.Metric, desc *prometheus.Desc, value float64) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, e.labelValues()...)
+}
+
+func (e *TorDaemonMetricsExporter) scrapeTraffic(ch chan<- prometheus.Metric) error {
+	// tor reports the totals since it started, so they are exported as is
+	// instead of being added onto a counter
 	if trafficRead, err := e.getInfoFloat("traffic/read"); err != nil {
 		return errors.Wrap(err, "could not scrape read_bytes")
 	} else {
-		trafficReadCounter.Add(trafficRead)
+		ch <- prometheus.MustNewConstMetric(trafficReadDesc, prometheus.CounterValue, trafficRead, e.labelValues()...)
 	}
 
 	if trafficWritten, err := e.getInfoFloat("traffic/written"); err != nil {
 		return errors.Wrap(err, "could not scrape written_bytes")
 	} else {
-		trafficWrittenCounter.Add(trafficWritten)
+		ch <- prometheus.MustNewConstMetric(trafficWrittenDesc, prometheus.CounterValue, trafficWritten, e.labelValues()...)
 	}
 
 	return nil
 }
 
-func (e *TorDaemonMetricsExporter) scrapeStatus() error {
+func (e *TorDaemonMetricsExporter) scrapeStatus(ch chan<- prometheus.Metric) error {
 	if circuitsCount, err := e.linesWithMatch("circuit-status", " BUILT "); err != nil {
 		return errors.Wrapf(err, "could not scrape circuit-status")
 	} else {
-		circuitsCountGauge.Set(float64(circuitsCount))
+		e.gauge(ch, circuitsCountDesc, float64(circuitsCount))
 	}
 
 	if streamsCount, err := e.linesWithMatch("stream-status", "SUCCEEDED"); err != nil {
 		return errors.Wrapf(err, "could not scrape stream-status")
 	} else {
-		streamsCountGauge.Set(float64(streamsCount))
+		e.gauge(ch, streamsCountDesc, float64(streamsCount))
 	}
 
 	if orconnsCount, err := e.linesWithMatch("orconn-status", " CONNECTED"); err != nil {
 		return errors.Wrapf(err, "could not scrape orconn-status")
 	} else {
-		orconnsCountGauge.Set(float64(orconnsCount))
+		e.gauge(ch, orconnsCountDesc, float64(orconnsCount))
+	}
+
+	if progress, err := e.Conn.BootstrapProgress(); err != nil {
+		return errors.Wrapf(err, "could not scrape bootstrap-phase")
+	} else {
+		e.gauge(ch, bootstrapProgressDesc, float64(progress))
 	}
 
 	return nil
 }
 
-func (e *TorDaemonMetricsExporter) Start(socket, port string) error {
-	if err := e.Connect(socket, port); err != nil {
-		return err
+func (e *TorDaemonMetricsExporter) scrapeHiddenService(ch chan<- prometheus.Metric) error {
+	if introCount, err := e.linesWithMatch("circuit-status", "PURPOSE=HS_SERVICE_INTRO", "HS_STATE=HSSI_ESTABLISHED"); err != nil {
+		return errors.Wrapf(err, "could not scrape introduction circuits")
+	} else {
+		e.gauge(ch, introPointsDesc, float64(introCount))
+	}
+
+	if rendCount, err := e.linesWithMatch("circuit-status", "PURPOSE=HS_SERVICE_REND", "HS_STATE=HSSR_JOINED"); err != nil {
+		return errors.Wrapf(err, "could not scrape rendezvous circuits")
+	} else {
+		e.gauge(ch, rendezvousCircuitsDesc, float64(rendCount))
+	}
+
+	e.collectUploads(ch)
+
+	return nil
+}
+
+func (e *TorDaemonMetricsExporter) collectUploads(ch chan<- prometheus.Metric) {
+	e.uploadsMu.Lock()
+	defer e.uploadsMu.Unlock()
+
+	for address, uploads := range e.uploads {
+		hostname := address + ".onion"
+		ch <- prometheus.MustNewConstMetric(descriptorUploadsDesc, prometheus.CounterValue,
+			float64(uploads.succeeded), e.labelValues(hostname, "success")...)
+		ch <- prometheus.MustNewConstMetric(descriptorUploadsDesc, prometheus.CounterValue,
+			float64(uploads.failed), e.labelValues(hostname, "failure")...)
+	}
+}
+
+// scrapeMetricsPort exports the DoS defense metrics tor only reports on its
+// MetricsPort, summed over all onions and rejection reasons.
+func (e *TorDaemonMetricsExporter) scrapeMetricsPort(ch chan<- prometheus.Metric) error {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s/metrics", e.MetricsPort))
+	if err != nil {
+		return errors.Wrap(err, "could not scrape MetricsPort")
+	}
+	defer resp.Body.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "could not parse MetricsPort response")
 	}
 
-	metrics.Registry.MustRegister(trafficWrittenCounter, trafficReadCounter, orconnsCountGauge, streamsCountGauge, circuitsCountGauge)
+	sum := func(name string) float64 {
+		var total float64
+		if family, ok := families[name]; ok {
+			for _, m := range family.GetMetric() {
+				total += m.GetCounter().GetValue() + m.GetGauge().GetValue() + m.GetUntyped().GetValue()
+			}
+		}
+		return total
+	}
+
+	ch <- prometheus.MustNewConstMetric(rejectedIntroductionsDesc, prometheus.CounterValue,
+		sum("tor_hs_intro_rejected_intro_req_count"), e.labelValues()...)
+	e.gauge(ch, powSuggestedEffortDesc, sum("tor_hs_pow_suggested_effort"))
+
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (e *TorDaemonMetricsExporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- circuitsCountDesc
+	ch <- streamsCountDesc
+	ch <- orconnsCountDesc
+	ch <- trafficReadDesc
+	ch <- trafficWrittenDesc
+	ch <- bootstrapProgressDesc
+	ch <- descriptorUploadsDesc
+	ch <- introPointsDesc
+	ch <- rendezvousCircuitsDesc
+	ch <- rejectedIntroductionsDesc
+	ch <- powSuggestedEffortDesc
+}
+
+// Collect implements prometheus.Collector. The control connection is opened
+// lazily and dropped on errors, so a restart of tor only fails the scrapes
+// until tor accepts connections again.
+func (e *TorDaemonMetricsExporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.Conn == nil {
+		if err := e.Connect(); err != nil {
+			ch <- prometheus.NewInvalidMetric(circuitsCountDesc, err)
+			return
+		}
+	}
+
+	for _, scrape := range []func(chan<- prometheus.Metric) error{e.scrapeTraffic, e.scrapeStatus, e.scrapeHiddenService} {
+		if err := scrape(ch); err != nil {
+			ch <- prometheus.NewInvalidMetric(circuitsCountDesc, err)
+			e.Close()
+			return
+		}
+	}
+
+	if e.MetricsPort != "" {
+		if err := e.scrapeMetricsPort(ch); err != nil {
+			ch <- prometheus.NewInvalidMetric(rejectedIntroductionsDesc, err)
+		}
+	}
+}
+
+// Register adds the exporter to the registry served on the metrics endpoint
+// of the manager and starts counting descriptor uploads right away.
+func (e *TorDaemonMetricsExporter) Register() error {
+	e.mu.Lock()
+	// tor is usually not up yet, Collect retries the connection
+	if e.Conn == nil {
+		_ = e.Connect()
+	}
+	e.mu.Unlock()
+
+	for _, c := range []prometheus.Collector{e, SelfTestSuccess, SelfTestDuration} {
+		if err := metrics.Registry.Register(c); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/marcus-sa/tor-operator/pkg/control"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// uploadsCollector collects only the descriptor uploads of the exporter,
+// which needs no control connection.
+type uploadsCollector struct {
+	e *TorDaemonMetricsExporter
+}
+
+func (c uploadsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descriptorUploadsDesc
+}
+
+func (c uploadsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.e.collectUploads(ch)
+}
+
+func TestDescriptorUploads(t *testing.T) {
+	e := &TorDaemonMetricsExporter{OnionServiceName: "frontends", OnionServiceNamespace: "default"}
+
+	for _, reply := range []string{
+		"HS_DESC UPLOAD aaaa NO_AUTH $F9A3A4E3C0B3C6C2D8E4E6FA1B2C3D4E5F607182 desc",
+		"HS_DESC UPLOADED aaaa NO_AUTH $F9A3A4E3C0B3C6C2D8E4E6FA1B2C3D4E5F607182",
+		"HS_DESC UPLOADED aaaa NO_AUTH $0A1B2C3D4E5F60718293A4B5C6D7E8F901234567",
+		"HS_DESC FAILED aaaa NO_AUTH $0A1B2C3D4E5F60718293A4B5C6D7E8F901234567 REASON=UPLOAD_REJECTED",
+		"HS_DESC UPLOADED bbbb NO_AUTH $F9A3A4E3C0B3C6C2D8E4E6FA1B2C3D4E5F607182",
+	} {
+		event, ok := control.ParseHSDescEvent(reply)
+		if !ok {
+			t.Fatalf("ParseHSDescEvent(%q) failed", reply)
+		}
+		e.countUpload(event)
+	}
+
+	want := `
+# HELP tor_daemon_hs_descriptor_uploads_total Count of hidden service descriptor uploads to HSDirs by onion address and result
+# TYPE tor_daemon_hs_descriptor_uploads_total counter
+tor_daemon_hs_descriptor_uploads_total{onion_address="aaaa.onion",onion_name="frontends",onion_namespace="default",result="failure"} 1
+tor_daemon_hs_descriptor_uploads_total{onion_address="aaaa.onion",onion_name="frontends",onion_namespace="default",result="success"} 2
+tor_daemon_hs_descriptor_uploads_total{onion_address="bbbb.onion",onion_name="frontends",onion_namespace="default",result="failure"} 0
+tor_daemon_hs_descriptor_uploads_total{onion_address="bbbb.onion",onion_name="frontends",onion_namespace="default",result="success"} 1
+`
+	if err := testutil.CollectAndCompare(uploadsCollector{e}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
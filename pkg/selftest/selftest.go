@@ -0,0 +1,63 @@
+package selftest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	// ProtocolTCP only checks that a connection to the port can be opened.
+	ProtocolTCP = "TCP"
+	// ProtocolHTTP sends a GET request and accepts any HTTP response.
+	ProtocolHTTP = "HTTP"
+)
+
+// Check connects to port of the onion hostname through the SocksPort of tor
+// listening on socksAddr.
+func Check(ctx context.Context, socksAddr, hostname string, port int32, protocol string) error {
+	dialer, err := proxy.SOCKS5("tcp", socksAddr, nil, proxy.Direct)
+	if err != nil {
+		return errors.Wrap(err, "failed to create SOCKS dialer")
+	}
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return errors.New("SOCKS dialer does not support contexts")
+	}
+
+	address := net.JoinHostPort(hostname, fmt.Sprint(port))
+
+	if protocol != ProtocolHTTP {
+		conn, err := contextDialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return errors.Wrapf(err, "failed to connect to %s", address)
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:       contextDialer.DialContext,
+			DisableKeepAlives: true,
+		},
+		// the backend answering at all is what matters
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+address+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "failed to GET %s", address)
+	}
+	return resp.Body.Close()
+}